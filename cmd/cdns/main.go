@@ -1,14 +1,19 @@
 package main
 
 import (
+	"cDNS/internal/blocklist"
+	"cDNS/internal/catalog"
+	"cDNS/internal/check"
 	"cDNS/internal/config"
 	"cDNS/internal/logger"
 	"cDNS/internal/server"
+	"encoding/json"
 	"fmt"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"os"
 	"runtime"
+	"strings"
 
 	"cDNS/internal/dns"
 )
@@ -30,29 +35,65 @@ func PrintVersion() {
 }
 
 func ShowDNSList(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+	tags, _ := cmd.Flags().GetStringSlice("tag")
+	resolvers := catalog.Get().Filter(tags)
+	health, _ := cmd.Flags().GetBool("health")
+	var checked []dns.ResolverHealth
+	if health {
+		checked = dns.CatalogHealth(resolvers, cfg, nil)
+	}
+
+	if cfg.JSONOutput {
+		var v interface{} = resolvers
+		if health {
+			v = checked
+		}
+		jsonOutput, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to marshal resolvers", zap.Error(err))
+		}
+		fmt.Println(string(jsonOutput))
+		return
+	}
+
 	fmt.Println("Popular DNS Servers:")
 	fmt.Println("====================")
-	dnsInfo := map[string]string{
-		"8.8.8.8":         "Google DNS",
-		"8.8.4.4":         "Google DNS",
-		"1.1.1.1":         "Cloudflare DNS",
-		"1.0.0.1":         "Cloudflare DNS",
-		"9.9.9.9":         "Quad9 DNS",
-		"149.112.112.112": "Quad9 DNS",
-		"208.67.222.222":  "OpenDNS",
-		"208.67.220.220":  "OpenDNS",
-		"76.76.19.19":     "Alternate DNS",
-		"76.223.100.101":  "Alternate DNS",
-		"94.140.14.14":    "AdGuard DNS",
-		"94.140.15.15":    "AdGuard DNS",
-		"77.88.8.8":       "Yandex DNS",
-		"77.88.8.1":       "Yandex DNS",
-	}
-	for _, dnsServer := range dns.PopularDNSServers {
-		fmt.Printf("%-15s - %s\n", dnsServer, dnsInfo[dnsServer])
+	for i, r := range resolvers {
+		dnssec := "no"
+		if r.DNSSEC {
+			dnssec = "yes"
+		}
+		fmt.Printf("\n%s (@%s) - filtering: %s, DNSSEC: %s\n", r.Name, r.ID, r.Filtering, dnssec)
+		if health {
+			for _, id := range checked[i].Health {
+				fmt.Printf("  %s\n", id.Summary())
+			}
+		} else {
+			for _, address := range r.Addresses() {
+				fmt.Printf("  %s\n", address)
+			}
+		}
+		for _, host := range r.DoT {
+			fmt.Printf("  tls://%s\n", host)
+		}
+		for _, url := range r.DoH {
+			fmt.Printf("  %s\n", url)
+		}
+		for _, url := range r.DoQ {
+			fmt.Printf("  %s\n", url)
+		}
+		if len(r.Tags) > 0 {
+			fmt.Printf("  tags: %s\n", strings.Join(r.Tags, ", "))
+		}
 	}
 	fmt.Println("\nUsage Examples:")
 	fmt.Println("cdns query google.com 8.8.8.8 1.1.1.1")
+	fmt.Println("cdns query google.com @quad9")
 	fmt.Println("cdns query -j example.com 8.8.8.8")
 	fmt.Println("cdns query --filter A,AAAA cloudflare.com 1.1.1.1")
 }
@@ -70,12 +111,17 @@ func main() {
 	queryCmd := &cobra.Command{
 		Use:   "query [domain] [nameservers...]",
 		Short: "Query DNS records",
-		Long:  `Query DNS records from specified nameservers`,
+		Long:  `Query DNS records from specified nameservers, or from the system resolvers in resolv.conf when none are given`,
 		Args:  cobra.MinimumNArgs(1), // Require at least 1 argument (domain)
 		Run:   dns.Query,
 		Example: `  cdns query google.com 8.8.8.8 1.1.1.1
   cdns query -j example.com 8.8.8.8
-  cdns query --filter A,AAAA cloudflare.com 1.1.1.1`,
+  cdns query --filter A,AAAA cloudflare.com 1.1.1.1
+  cdns query example.com @google @cloudflare
+  cdns query --resolv-conf ./resolv.conf example.com
+  cdns query --rules ./split-horizon.yaml intranet.corp.internal
+  cdns query --follow www.github.com 1.1.1.1
+  cdns query --search corp.example.com db01 10.0.0.53`,
 	}
 
 	apiCmd := &cobra.Command{
@@ -85,6 +131,149 @@ func main() {
 		Run:   server.RunAPI,
 	}
 
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a caching DNS forwarder and local authoritative server",
+		Long:  `Answer DNS clients over UDP and TCP from local zone files, forwarding other queries to the given upstreams`,
+		Run:   server.RunServe,
+		Example: `  cdns serve --listen :5353 --upstream 1.1.1.1 --upstream tls://9.9.9.9
+  cdns serve --upstream https://dns.google/dns-query --strategy fastest --api-port 8080
+  cdns serve --zone example.test=./example.test.zone
+  cdns serve --rules ./split-horizon.yaml
+  cdns serve --upstream 1.1.1.1 --blocklist ads=./hosts.txt --allowlist ./allow.txt --block-response null
+  cdns serve --upstream 1.1.1.1 --dnstap unix:///var/run/dnstap.sock`,
+	}
+
+	checkZoneCmd := &cobra.Command{
+		Use:   "check-zone [zone]",
+		Short: "Audit a zone's delegation and authoritative servers",
+		Long:  `Check that the parent and zone agree on the NS set and glue, that no server is lame, SOA serials and timers, recursion, TCP and EDNS support on every authoritative server`,
+		Args:  cobra.ExactArgs(1),
+		Run:   check.RunCheckZone,
+		Example: `  cdns check-zone example.com
+  cdns check-zone --resolver 1.1.1.1 -j example.com`,
+	}
+
+	mailCmd := &cobra.Command{
+		Use:   "mail [domain]",
+		Short: "Check a domain's email authentication records",
+		Long:  `Check SPF (including the 10 DNS lookup limit), DMARC, DKIM selectors, MTA-STS, TLS-RPT and BIMI records and explain misconfigurations`,
+		Args:  cobra.ExactArgs(1),
+		Run:   check.RunMail,
+		Example: `  cdns mail example.com
+  cdns mail --selector google --selector s1 example.com
+  cdns mail --resolver 1.1.1.1 -j example.com`,
+	}
+
+	caaCmd := &cobra.Command{
+		Use:   "caa [name]",
+		Short: "Evaluate the CAA issuance policy of a name",
+		Long:  `Find the relevant CAA record set of a name as a CA would (RFC 8659), following CNAMEs and climbing to parent domains, and report which CAs may issue normal and wildcard certificates`,
+		Args:  cobra.ExactArgs(1),
+		Run:   check.RunCAA,
+		Example: `  cdns caa www.example.com
+  cdns caa www.example.com --ca letsencrypt.org
+  cdns caa --resolver 1.1.1.1 -j example.com --ca pki.goog`,
+	}
+
+	enumCmd := &cobra.Command{
+		Use:   "enum [domain] [nameservers...]",
+		Short: "Discover subdomains from a wordlist",
+		Long:  `Query every word of a wordlist as a subdomain, concurrently and spread over the given nameservers, filtering wildcard answers and streaming the names found`,
+		Args:  cobra.MinimumNArgs(1),
+		Run:   dns.Enum,
+		Example: `  cdns enum example.com --wordlist words.txt
+  cdns enum example.com 1.1.1.1 8.8.8.8 --wordlist words.txt --concurrency 50
+  cat words.txt | cdns enum example.com --wordlist - -j -f A,MX`,
+	}
+
+	nsecWalkCmd := &cobra.Command{
+		Use:   "nsec-walk [zone] [nameserver]",
+		Short: "Enumerate a DNSSEC-signed zone through its NSEC or NSEC3 records",
+		Long:  `Follow the NSEC chain of a zone to list its names, or collect NSEC3 hashes with their salt and iterations for offline analysis, and report how enumerable the zone is. Without a nameserver the zone's first authoritative server is used`,
+		Args:  cobra.RangeArgs(1, 2),
+		Run:   check.RunNSECWalk,
+		Example: `  cdns nsec-walk example.com
+  cdns nsec-walk example.com ns1.example.com --max-queries 5000
+  cdns nsec-walk example.org --hashes example.org.hashes`,
+	}
+
+	auditResolverCmd := &cobra.Command{
+		Use:   "audit-resolver [nameserver]",
+		Short: "Audit the behavior of a recursive resolver",
+		Long:  `Check whether a resolver answers recursive queries for this client, uses QNAME minimisation, preserves 0x20 mixed case, filters private answers against DNS rebinding, validates DNSSEC, forwards the client subnet and how much it amplifies ANY and DNSKEY queries`,
+		Args:  cobra.ExactArgs(1),
+		Run:   check.RunAuditResolver,
+		Example: `  cdns audit-resolver 192.168.1.1
+  cdns audit-resolver tls://9.9.9.9 -j
+  cdns audit-resolver 127.0.0.1:5353 --probe dnssec=bogus.example.test --probe rebinding=lan.example.test`,
+	}
+
+	identifyCmd := &cobra.Command{
+		Use:   "identify [nameservers...]",
+		Short: "Identify the software and instance behind nameservers",
+		Long:  `Send CHAOS class TXT queries for version.bind, hostname.bind, id.server and version.server together with the NSID EDNS option, showing which anycast instance and server software answered`,
+		Run:   dns.Identify,
+		Example: `  cdns identify 1.1.1.1 8.8.8.8 9.9.9.9
+  cdns identify @quad9 -j
+  cdns identify a.root-servers.net`,
+	}
+
+	interceptCheckCmd := &cobra.Command{
+		Use:   "intercept-check",
+		Short: "Detect DNS hijacking and transparent interception",
+		Long:  `Look for a middlebox answering DNS on port 53: queries to unroutable documentation addresses, plain answers and whoami responses compared with the same resolvers over DNS over TLS or HTTPS, and NXDOMAIN rewriting`,
+		Args:  cobra.NoArgs,
+		Run:   check.RunInterceptCheck,
+		Example: `  cdns intercept-check
+  cdns intercept-check --resolver 192.168.1.1 --encrypted tls://9.9.9.9 --encrypted https://dns.google/dns-query
+  cdns intercept-check -j -o intercept.json`,
+	}
+
+	replayCmd := &cobra.Command{
+		Use:   "replay [capture.pcap]",
+		Short: "Resend the queries of a packet capture and diff the answers",
+		Long:  `Read the DNS queries and recorded responses of a pcap file, such as one written by query --pcap, send every query to a nameserver again and show how the new responses differ from the recorded ones`,
+		Args:  cobra.ExactArgs(1),
+		Run:   dns.Replay,
+		Example: `  cdns replay bug.pcap --to 9.9.9.9
+  cdns replay bug.pcap --to tls://1.1.1.1 --pcap replayed.pcap
+  cdns replay bug.pcap --to 127.0.0.1:5353 -j`,
+	}
+
+	analyzeCmd := &cobra.Command{
+		Use:   "analyze [capture]",
+		Short: "Summarize the DNS traffic of a pcap or dnstap file",
+		Long:  `Parse the DNS messages of a pcap or dnstap file offline and report the top queried names, response codes, record types, the slowest transactions and queries that never got a response`,
+		Args:  cobra.ExactArgs(1),
+		Run:   dns.Analyze,
+		Example: `  cdns analyze capture.pcap
+  cdns analyze --top 20 resolver.tap
+  cdns analyze -j -o report.json capture.pcap`,
+	}
+
+	testCmd := &cobra.Command{
+		Use:   "test [spec.yaml]",
+		Short: "Evaluate DNS assertions from a spec file for CI",
+		Long:  `Query the names of a YAML/JSON spec file on the given or authoritative nameservers and check their records with exact, contains, regex, absent and TTL assertions. Results are written as TAP or JUnit XML and the command exits non-zero when a test fails`,
+		Args:  cobra.ExactArgs(1),
+		Run:   check.RunTest,
+		Example: `  cdns test dns-tests.yaml
+  cdns test dns-tests.yaml --format junit -o dns-tests.xml
+  cdns test dns-tests.yaml --resolver 9.9.9.9`,
+	}
+
+	driftCmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Compare a zone file with what its nameservers serve",
+		Long:  `Parse a zone file, query every RRset in it on each nameserver and report records that are missing, extra or different, including TTL differences, as a patch from the file to the live zone`,
+		Args:  cobra.NoArgs,
+		Run:   check.RunDrift,
+		Example: `  cdns drift --zone example.com.zone --ns ns1 --ns ns2
+  cdns drift --zone example.com=./zones/example.com --axfr
+  cdns drift --zone example.com.zone --ns 192.0.2.53 -j`,
+	}
+
 	versionCmd := &cobra.Command{
 		Use:   "version",
 		Short: "Show version information",
@@ -96,20 +285,79 @@ func main() {
 	dnsListCmd := &cobra.Command{
 		Use:   "dns-list",
 		Short: "Show popular DNS servers",
+		Long:  `Show the resolver catalog: the built-in entries merged with the file given by --catalog`,
 		Run:   ShowDNSList,
+		Example: `  cdns dns-list
+  cdns dns-list --tag malware
+  cdns dns-list --catalog ./resolvers.yaml -j
+  cdns dns-list --tag malware --health`,
 	}
 
 	// Global flags
 	config.AddGlobalFlags(rootCmd)
 	apiCmd.Flags().IntP("port", "p", 8080, "API server port")
+	apiCmd.Flags().String("dnstap", "", "Log queries and responses as dnstap (unix:///path, tcp://host:port or a file)")
+
+	dnsListCmd.Flags().StringSlice("tag", []string{}, "Only show resolvers with all of these tags or filtering policies")
+	dnsListCmd.Flags().Bool("health", false, "Check every address and show its NSID and CHAOS identity")
+
+	// Add flags for serve command
+	serveCmd.Flags().String("listen", ":5353", "Address to listen on for UDP and TCP")
+	serveCmd.Flags().StringSlice("upstream", []string{}, "Upstream nameserver (udp, tcp://, tls:// or https://)")
+	serveCmd.Flags().String("strategy", "random", "Upstream selection strategy (random, fastest, failover)")
+	serveCmd.Flags().Int("cache-size", 4096, "Maximum number of cached responses (0 disables caching)")
+	serveCmd.Flags().Int("api-port", 0, "Also start the API server on this port")
+	serveCmd.Flags().StringSlice("zone", []string{}, "Serve a zone authoritatively from an RFC 1035 zone file (origin=path)")
+	serveCmd.Flags().String("rules", "", "Conditional forwarding rules file (YAML or JSON)")
+	serveCmd.Flags().StringSlice("blocklist", []string{}, "Blocklist file in hosts, AdBlock or domain format ([name=]path)")
+	serveCmd.Flags().StringSlice("allowlist", []string{}, "Allowlist file overriding blocklists ([name=]path)")
+	serveCmd.Flags().String("blocklist-dir", "", "Directory the API may load lists from (loading lists over the API is disabled without it)")
+	serveCmd.Flags().String("block-response", blocklist.ResponseNXDomain, "Answer for blocked names (nxdomain, null or an IP address)")
+	serveCmd.Flags().String("dnstap", "", "Log client and upstream traffic as dnstap (unix:///path, tcp://host:port or a file)")
+
+	checkZoneCmd.Flags().String("resolver", "", "Recursive resolver used to find the parent zone and nameserver addresses (default from resolv.conf)")
+	mailCmd.Flags().String("resolver", "", "Recursive resolver used for lookups (default from resolv.conf)")
+	mailCmd.Flags().StringSlice("selector", []string{}, "DKIM selector to check (default: a list of common selectors)")
+	caaCmd.Flags().String("resolver", "", "Recursive resolver used for lookups (default from resolv.conf)")
+	caaCmd.Flags().String("ca", "", "Issuer domain name of the CA to evaluate, e.g. letsencrypt.org")
+	enumCmd.Flags().String("wordlist", "", "File with one subdomain label per line (- for stdin)")
+	enumCmd.Flags().Int("concurrency", 20, "Number of names queried in parallel")
+	_ = enumCmd.MarkFlagRequired("wordlist")
+	nsecWalkCmd.Flags().String("resolver", "", "Recursive resolver used to find the zone's nameservers (default from resolv.conf)")
+	nsecWalkCmd.Flags().Int("max-queries", 1000, "Maximum number of queries sent while walking")
+	nsecWalkCmd.Flags().String("hashes", "", "Write collected NSEC3 hashes to this file in hashcat format (mode 8300)")
+	auditResolverCmd.Flags().StringSlice("probe", []string{}, "Override the name queried by a check (check=name, checks: recursion, qname-min, 0x20, rebinding, dnssec, ecs, amplification)")
+	interceptCheckCmd.Flags().String("resolver", "", "Plain resolver checked for NXDOMAIN rewriting (default from resolv.conf)")
+	interceptCheckCmd.Flags().StringSlice("encrypted", check.DefaultEncryptedResolvers, "Resolver compared over DoT/DoH and plain DNS to the same address")
+	interceptCheckCmd.Flags().StringSlice("unroutable", check.DefaultUnroutable, "Address where no DNS server exists, any answer reveals interception")
+	interceptCheckCmd.Flags().String("name", "example.com", "Name whose answers are compared")
+	interceptCheckCmd.Flags().String("whoami", "o-o.myaddr.l.google.com", "TXT name answered with the address of the querying resolver")
+	replayCmd.Flags().String("to", "", "Nameserver the captured queries are sent to")
+	replayCmd.Flags().String("pcap", "", "Write the replayed queries and responses to this pcap file")
+	_ = replayCmd.MarkFlagRequired("to")
+	testCmd.Flags().String("resolver", "", "Recursive resolver used to find zones and authoritative servers, and the default nameserver (default from resolv.conf)")
+	testCmd.Flags().String("format", check.FormatTAP, "Output format (tap or junit)")
+	driftCmd.Flags().String("zone", "", "Zone file to compare (path named after the zone, or origin=path)")
+	driftCmd.Flags().StringSlice("ns", []string{}, "Nameserver to compare against; bare labels are relative to the zone (default: the apex NS records of the file)")
+	driftCmd.Flags().Bool("axfr", false, "Try a zone transfer first, which also finds RRsets missing from the file")
+	_ = driftCmd.MarkFlagRequired("zone")
+	analyzeCmd.Flags().Int("top", 10, "Number of entries shown in the name, slowest and unanswered lists (0 for all)")
 
 	// Add flags for query command
 	queryCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
-	queryCmd.Flags().StringP("filter", "f", "", "Filter record types (e.g., A,AAAA,MX)")
+	queryCmd.Flags().StringSliceP("filter", "f", []string{}, "Filter record types (e.g., A,AAAA,MX)")
 	queryCmd.Flags().IntP("timeout", "t", 5, "Query timeout in seconds")
 	queryCmd.Flags().BoolP("verbose", "v", false, "Verbose output")
+	queryCmd.Flags().String("name-mode", string(dns.ModeDNSName), "Domain validation mode (dns or hostname)")
+	queryCmd.Flags().String("resolv-conf", dns.DefaultResolvConf, "Resolver configuration used when no nameservers are given")
+	queryCmd.Flags().String("rules", "", "Pick nameservers from a conditional forwarding rules file when none are given")
+	queryCmd.Flags().StringSlice("search", []string{}, "Search domains for relative names (default: resolv.conf's list when its nameservers are used)")
+	queryCmd.Flags().Int("ndots", dns.DefaultNdots, "Names with fewer dots are tried with the search domains first")
+	queryCmd.Flags().Bool("follow", false, "Follow the CNAME/DNAME chain to the final A/AAAA records")
+	queryCmd.Flags().String("pcap", "", "Write every query and response to this pcap file")
+	queryCmd.Flags().String("dnstap", "", "Log every query and response as dnstap (unix:///path, tcp://host:port or a file)")
 
-	rootCmd.AddCommand(queryCmd, apiCmd, versionCmd, dnsListCmd)
+	rootCmd.AddCommand(queryCmd, apiCmd, serveCmd, checkZoneCmd, mailCmd, caaCmd, enumCmd, nsecWalkCmd, auditResolverCmd, identifyCmd, interceptCheckCmd, replayCmd, analyzeCmd, testCmd, driftCmd, versionCmd, dnsListCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.GetLogger().Fatal("Failed to execute command", zap.Error(err))
@@ -2,17 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/miekg/dns"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"cDNS/internal/blocklist"
+	"cDNS/internal/catalog"
 	"cDNS/internal/config"
 	ldns "cDNS/internal/dns"
+	"cDNS/internal/resolver"
 	"cDNS/internal/task"
 )
 
@@ -26,8 +31,12 @@ var taskManager = task.Manager
 type BackgroundTask = task.BackgroundTask
 
 type Handler struct {
-	logger *zap.Logger
-	router *gin.Engine
+	logger     *zap.Logger
+	router     *gin.Engine
+	resolver   *resolver.Server
+	blocklists *blocklist.Manager
+	defaults   config.Config
+	recorder   ldns.Recorder
 }
 
 func NewHandler(logger *zap.Logger) *Handler {
@@ -37,6 +46,28 @@ func NewHandler(logger *zap.Logger) *Handler {
 	}
 }
 
+// SetQueryDefaults sets the configuration used for request fields left empty,
+// such as the source address of outgoing queries.
+func (h *Handler) SetQueryDefaults(cfg config.Config) {
+	h.defaults = cfg
+}
+
+// SetRecorder passes the messages exchanged for every request to rec, e.g. a
+// dnstap output.
+func (h *Handler) SetRecorder(rec ldns.Recorder) {
+	h.recorder = rec
+}
+
+// SetResolver exposes the statistics of a running serve-mode DNS server.
+func (h *Handler) SetResolver(s *resolver.Server) {
+	h.resolver = s
+}
+
+// SetBlocklists enables management of the serve-mode block and allow lists.
+func (h *Handler) SetBlocklists(m *blocklist.Manager) {
+	h.blocklists = m
+}
+
 func (h *Handler) SetupRoutes() {
 	h.router.Use(h.ginLogger())
 	h.router.Use(gin.Recovery())
@@ -46,8 +77,14 @@ func (h *Handler) SetupRoutes() {
 		v1.GET("/dns-servers", h.GetDNSServers)
 		v1.POST("/query", h.QueryEndpoint)
 		v1.POST("/query/background", h.BackgroundQueryEndpoint)
+		v1.POST("/identify", h.IdentifyEndpoint)
 		v1.GET("/task/:id", h.GetTaskEndpoint)
 		v1.GET("/tasks", h.GetTasksEndpoint)
+		v1.GET("/serve/stats", h.ServeStatsEndpoint)
+		v1.GET("/blocklists", h.GetBlocklistsEndpoint)
+		v1.POST("/blocklists", h.AddBlocklistEndpoint)
+		v1.POST("/blocklists/reload", h.ReloadBlocklistsEndpoint)
+		v1.DELETE("/blocklists/:name", h.DeleteBlocklistEndpoint)
 	}
 }
 
@@ -87,90 +124,143 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 }
 
 func (h *Handler) GetDNSServers(c *gin.Context) {
-	servers := make([]map[string]string, 0, len(ldns.PopularDNSServers))
-	dnsInfo := map[string]string{
-		"8.8.8.8":         "Google DNS",
-		"8.8.4.4":         "Google DNS",
-		"1.1.1.1":         "Cloudflare DNS",
-		"1.0.0.1":         "Cloudflare DNS",
-		"9.9.9.9":         "Quad9 DNS",
-		"149.112.112.112": "Quad9 DNS",
-		"208.67.222.222":  "OpenDNS",
-		"208.67.220.220":  "OpenDNS",
-		"76.76.19.19":     "Alternate DNS",
-		"76.223.100.101":  "Alternate DNS",
-		"94.140.14.14":    "AdGuard DNS",
-		"94.140.15.15":    "AdGuard DNS",
-		"77.88.8.8":       "Yandex DNS",
-		"77.88.8.1":       "Yandex DNS",
-	}
-	for _, server := range ldns.PopularDNSServers {
-		servers = append(servers, map[string]string{
-			"ip":   server,
-			"name": dnsInfo[server],
-		})
+	var tags []string
+	if tag := c.Query("tag"); tag != "" {
+		tags = strings.Split(tag, ",")
+	}
+	resolvers := catalog.Get().Filter(tags)
+	if c.Query("health") == "true" {
+		c.JSON(http.StatusOK, gin.H{"resolvers": ldns.CatalogHealth(resolvers, task.QueryRequest{}.Config(h.defaults), h.recorder)})
+		return
+	}
+	servers := make([]map[string]string, 0, len(resolvers))
+	for _, r := range resolvers {
+		for _, address := range r.Addresses() {
+			servers = append(servers, map[string]string{
+				"ip":   address,
+				"name": r.Name,
+			})
+		}
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"dns_servers": servers,
+		"resolvers":   resolvers,
 	})
 }
 
 func (h *Handler) QueryEndpoint(c *gin.Context) {
-	var req struct {
-		Domain      string   `json:"domain" binding:"required"`
-		Nameservers []string `json:"nameservers" binding:"required"`
-		Timeout     int      `json:"timeout,omitempty"`
-		Retries     int      `json:"retries,omitempty"`
-		Filter      []string `json:"filter,omitempty"`
-	}
+	var req task.QueryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	cfg := config.Config{
-		Timeout:      time.Duration(req.Timeout) * time.Second,
-		Retries:      req.Retries,
-		RecordFilter: req.Filter,
-	}
-	if cfg.Timeout == 0 {
-		cfg.Timeout = 5 * time.Second
-	}
-	if cfg.Retries == 0 {
-		cfg.Retries = 3
+	cfg := req.Config(h.defaults)
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Ensure domain is fully qualified
-	domain := dns.Fqdn(req.Domain)
+	domain, err := ldns.ToASCII(req.Domain)
+	if err != nil {
+		invalidName(c, "domain", &ldns.ValidationError{Reason: ldns.ReasonInvalidIDN, Message: err.Error()})
+		return
+	}
 
-	if !ldns.IsValidDomain(domain) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain"})
+	mode, err := ldns.ParseValidationMode(req.NameMode)
+	if err == nil {
+		err = ldns.ValidateDomain(domain, mode)
+	}
+	if err != nil {
+		invalidName(c, "domain", err)
+		return
+	}
+	if cfg.Search, err = ldns.SearchList(req.Search, mode); err != nil {
+		invalidName(c, "search domain", err)
 		return
 	}
-	nameservers := ldns.PrepareNameservers(req.Nameservers)
+	nameservers := ldns.PrepareNameservers(req.Nameservers, cfg.IPVersion)
 	if len(nameservers) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid nameservers provided"})
 		return
 	}
 	var results []ldns.Result
 	for _, ns := range nameservers {
-		result := ldns.Nameserver(domain, ns, cfg)
+		result := ldns.Nameserver(domain, ns, cfg, h.recorder)
 		results = append(results, result)
 	}
 	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
-func (h *Handler) BackgroundQueryEndpoint(c *gin.Context) {
-	var req struct {
-		Domain      string   `json:"domain" binding:"required"`
-		Nameservers []string `json:"nameservers" binding:"required"`
-		Timeout     int      `json:"timeout,omitempty"`
-		Retries     int      `json:"retries,omitempty"`
-		Filter      []string `json:"filter,omitempty"`
+// IdentifyRequest is the body accepted by the identify endpoint.
+type IdentifyRequest struct {
+	Nameservers []string `json:"nameservers" binding:"required"`
+	Timeout     int      `json:"timeout,omitempty"`
+	Retries     int      `json:"retries,omitempty"`
+	IPVersion   int      `json:"ip_version,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Interface   string   `json:"interface,omitempty"`
+}
+
+func (h *Handler) IdentifyEndpoint(c *gin.Context) {
+	var req IdentifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cfg := task.QueryRequest{
+		Timeout:   req.Timeout,
+		Retries:   req.Retries,
+		IPVersion: req.IPVersion,
+		Source:    req.Source,
+		Interface: req.Interface,
+	}.Config(h.defaults)
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	nameservers := ldns.PrepareNameservers(req.Nameservers, cfg.IPVersion)
+	if len(nameservers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid nameservers provided"})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"results": ldns.IdentifyAll(nameservers, cfg, h.recorder)})
+}
+
+// invalidName replies 400 with the validator's reason for rejecting a name;
+// what says which name of the request it was.
+func invalidName(c *gin.Context, what string, err error) {
+	body := gin.H{"error": "Invalid " + what + ": " + err.Error()}
+	var verr *ldns.ValidationError
+	if errors.As(err, &verr) {
+		body["reason"] = verr.Reason
+		if verr.Label != "" {
+			body["label"] = verr.Label
+		}
+	}
+	c.JSON(http.StatusBadRequest, body)
+}
+
+func (h *Handler) BackgroundQueryEndpoint(c *gin.Context) {
+	var req task.QueryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	cfg := req.Config(h.defaults)
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Search) > 0 {
+		mode, err := ldns.ParseValidationMode(req.NameMode)
+		if err == nil {
+			cfg.Search, err = ldns.SearchList(req.Search, mode)
+		}
+		if err != nil {
+			invalidName(c, "search domain", err)
+			return
+		}
+	}
 	// Create task
 	taskID := fmt.Sprintf("task_%d", time.Now().UnixNano())
 	taskObj := &task.BackgroundTask{
@@ -181,7 +271,7 @@ func (h *Handler) BackgroundQueryEndpoint(c *gin.Context) {
 		CreatedAt:   time.Now(),
 	}
 	taskManager.AddTask(taskObj)
-	go task.ProcessBackgroundTask(taskID, req)
+	go task.ProcessBackgroundTask(taskID, req, cfg, h.recorder)
 	c.JSON(http.StatusAccepted, gin.H{"task_id": taskID, "status": "pending"})
 }
 
@@ -208,3 +298,72 @@ func (h *Handler) GetTasksEndpoint(c *gin.Context) {
 	tasks := taskManager.GetTasks(status)
 	c.JSON(http.StatusOK, gin.H{"tasks": tasks})
 }
+
+func (h *Handler) ServeStatsEndpoint(c *gin.Context) {
+	if h.resolver == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "DNS server is not running"})
+		return
+	}
+	c.JSON(http.StatusOK, h.resolver.Stats())
+}
+
+func (h *Handler) GetBlocklistsEndpoint(c *gin.Context) {
+	if h.blocklists == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "DNS server is not running"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocklists": h.blocklists.Lists()})
+}
+
+func (h *Handler) AddBlocklistEndpoint(c *gin.Context) {
+	if h.blocklists == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "DNS server is not running"})
+		return
+	}
+	var req struct {
+		Name  string `json:"name,omitempty"`
+		File  string `json:"file" binding:"required"`
+		Allow bool   `json:"allow,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := req.Name
+	if name == "" {
+		name = filepath.Base(req.File)
+	}
+	if err := h.blocklists.AddFile(name, req.File, req.Allow); err != nil {
+		if errors.Is(err, blocklist.ErrNoListDir) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "loading lists over the API requires serve --blocklist-dir"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"blocklists": h.blocklists.Lists()})
+}
+
+func (h *Handler) ReloadBlocklistsEndpoint(c *gin.Context) {
+	if h.blocklists == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "DNS server is not running"})
+		return
+	}
+	if err := h.blocklists.Reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "blocklists": h.blocklists.Lists()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocklists": h.blocklists.Lists()})
+}
+
+func (h *Handler) DeleteBlocklistEndpoint(c *gin.Context) {
+	if h.blocklists == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "DNS server is not running"})
+		return
+	}
+	if err := h.blocklists.Remove(c.Param("name")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
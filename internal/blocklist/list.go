@@ -0,0 +1,203 @@
+package blocklist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Hostnames commonly present in hosts files that must never be blocked.
+var hostsIgnored = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+type domainSet struct {
+	exact  map[string]struct{}
+	suffix map[string]struct{}
+}
+
+func newDomainSet() *domainSet {
+	return &domainSet{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+	}
+}
+
+func (d *domainSet) add(name string, subdomains bool) {
+	name = dns.CanonicalName(name)
+	if subdomains {
+		d.suffix[name] = struct{}{}
+	} else {
+		d.exact[name] = struct{}{}
+	}
+}
+
+func (d *domainSet) len() int {
+	return len(d.exact) + len(d.suffix)
+}
+
+// match reports whether name or, for subdomain entries, one of its parents is
+// in the set. name must be canonical.
+func (d *domainSet) match(name string) bool {
+	if _, ok := d.exact[name]; ok {
+		return true
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if _, ok := d.suffix[name[off:]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// List is a single blocklist or allowlist file. Hosts-format lines block the
+// exact names they list, while AdBlock-style "||domain^" rules and plain
+// domain lines also cover subdomains. "@@||domain^" exceptions are honoured
+// as allow entries.
+type List struct {
+	Name  string
+	Path  string
+	Allow bool
+
+	block    *domainSet
+	allow    *domainSet
+	loadedAt time.Time
+	hits     atomic.Uint64
+}
+
+type ListInfo struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Allow    bool      `json:"allow"`
+	Entries  int       `json:"entries"`
+	Hits     uint64    `json:"hits"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+func (l *List) Info() ListInfo {
+	return ListInfo{
+		Name:     l.Name,
+		Path:     l.Path,
+		Allow:    l.Allow,
+		Entries:  l.block.len() + l.allow.len(),
+		Hits:     l.hits.Load(),
+		LoadedAt: l.loadedAt,
+	}
+}
+
+// Load reads the list file, replacing any previously loaded entries.
+func (l *List) Load() error {
+	file, err := os.Open(l.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	block, allow := newDomainSet(), newDomainSet()
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		// Errors name the line but not its content, which may come from any
+		// file the process can read.
+		if err := parseLine(line, block, allow); err != nil {
+			return fmt.Errorf("%s:%d: %v", l.Path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if l.Allow {
+		// Everything in an allowlist is an exception.
+		for name := range block.exact {
+			allow.exact[name] = struct{}{}
+		}
+		for name := range block.suffix {
+			allow.suffix[name] = struct{}{}
+		}
+		block = newDomainSet()
+	}
+	l.block, l.allow = block, allow
+	l.loadedAt = time.Now()
+	return nil
+}
+
+func parseLine(line string, block, allow *domainSet) error {
+	switch {
+	case strings.HasPrefix(line, "@@||"):
+		if name, ok := adblockDomain(line[4:]); ok {
+			allow.add(name, true)
+		}
+		return nil
+	case strings.HasPrefix(line, "||"):
+		if name, ok := adblockDomain(line[2:]); ok {
+			block.add(name, true)
+		}
+		return nil
+	}
+	fields := strings.Fields(line)
+	if net.ParseIP(fields[0]) != nil {
+		for _, name := range fields[1:] {
+			if hostsIgnored[strings.ToLower(name)] {
+				continue
+			}
+			if _, ok := dns.IsDomainName(name); !ok {
+				return errors.New("invalid hostname")
+			}
+			block.add(name, false)
+		}
+		return nil
+	}
+	if len(fields) != 1 {
+		return errors.New("unrecognised entry")
+	}
+	name := strings.TrimPrefix(fields[0], "*.")
+	if _, ok := dns.IsDomainName(name); !ok {
+		return errors.New("invalid domain")
+	}
+	block.add(name, true)
+	return nil
+}
+
+// adblockDomain extracts the domain from the body of a "||domain^$options"
+// rule. Rules that are not plain domain anchors (paths, wildcards, cosmetic
+// filters) are skipped.
+func adblockDomain(rule string) (string, bool) {
+	if i := strings.IndexByte(rule, '$'); i >= 0 {
+		rule = rule[:i]
+	}
+	rule = strings.TrimSuffix(rule, "|")
+	rule = strings.TrimSuffix(rule, "^")
+	if rule == "" || strings.ContainsAny(rule, "/*^|") {
+		return "", false
+	}
+	if _, ok := dns.IsDomainName(rule); !ok {
+		return "", false
+	}
+	return rule, true
+}
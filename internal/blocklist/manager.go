@@ -0,0 +1,197 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	ResponseNXDomain = "nxdomain"
+	ResponseNull     = "null"
+)
+
+// blockedTTL is the TTL of synthesised answers for blocked names.
+const blockedTTL = 60
+
+var (
+	ErrListNotFound = errors.New("list not found")
+	ErrNoListDir    = errors.New("no list directory configured")
+)
+
+// Manager holds the loaded lists and decides how blocked names are answered:
+// with NXDOMAIN, with the unspecified address or with a custom IP.
+type Manager struct {
+	mu       sync.RWMutex
+	lists    []*List
+	response string
+	ip       net.IP
+	dir      string
+}
+
+func NewManager(response string) (*Manager, error) {
+	m := &Manager{response: strings.ToLower(response)}
+	switch m.response {
+	case ResponseNXDomain, ResponseNull:
+	default:
+		m.ip = net.ParseIP(response)
+		if m.ip == nil {
+			return nil, fmt.Errorf("block response must be %q, %q or an IP address, got %q", ResponseNXDomain, ResponseNull, response)
+		}
+	}
+	return m, nil
+}
+
+// ParseSpec splits a "name=path" list specification. Without a name the file's
+// base name is used.
+func ParseSpec(spec string) (string, string) {
+	if name, path, ok := strings.Cut(spec, "="); ok && name != "" {
+		return name, path
+	}
+	return filepath.Base(spec), spec
+}
+
+// SetDir sets the directory AddFile loads lists from.
+func (m *Manager) SetDir(dir string) error {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	m.dir = resolved
+	return nil
+}
+
+// AddFile loads a list by its file name inside the list directory, for
+// callers such as the API that must not read arbitrary files.
+func (m *Manager) AddFile(name, file string, allow bool) error {
+	if m.dir == "" {
+		return ErrNoListDir
+	}
+	if !filepath.IsLocal(file) {
+		return fmt.Errorf("%q is not a file in the list directory", file)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(m.dir, file))
+	if err != nil {
+		return fmt.Errorf("%q is not a file in the list directory", file)
+	}
+	if rel, err := filepath.Rel(m.dir, path); err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("%q is not a file in the list directory", file)
+	}
+	return m.Add(name, path, allow)
+}
+
+func (m *Manager) Add(name, path string, allow bool) error {
+	list := &List{Name: name, Path: path, Allow: allow}
+	if err := list.Load(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.lists {
+		if existing.Name == name {
+			m.lists[i] = list
+			return nil
+		}
+	}
+	m.lists = append(m.lists, list)
+	return nil
+}
+
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, list := range m.lists {
+		if list.Name == name {
+			m.lists = append(m.lists[:i], m.lists[i+1:]...)
+			return nil
+		}
+	}
+	return ErrListNotFound
+}
+
+// Reload re-reads every list from disk. Lists that fail to load keep their
+// previous entries and the errors are returned together.
+func (m *Manager) Reload() error {
+	m.mu.RLock()
+	lists := make([]*List, len(m.lists))
+	copy(lists, m.lists)
+	m.mu.RUnlock()
+
+	var errs []error
+	for _, list := range lists {
+		fresh := &List{Name: list.Name, Path: list.Path, Allow: list.Allow}
+		if err := fresh.Load(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", list.Name, err))
+			continue
+		}
+		fresh.hits.Store(list.hits.Load())
+		m.mu.Lock()
+		for i, current := range m.lists {
+			if current == list {
+				m.lists[i] = fresh
+			}
+		}
+		m.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) Lists() []ListInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := make([]ListInfo, 0, len(m.lists))
+	for _, list := range m.lists {
+		infos = append(infos, list.Info())
+	}
+	return infos
+}
+
+// Match reports the name of the list blocking name. Allowlists and "@@"
+// exceptions take precedence over every blocklist.
+func (m *Manager) Match(name string) (string, bool) {
+	name = dns.CanonicalName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, list := range m.lists {
+		if list.allow.match(name) {
+			list.hits.Add(1)
+			return "", false
+		}
+	}
+	for _, list := range m.lists {
+		if list.block.match(name) {
+			list.hits.Add(1)
+			return list.Name, true
+		}
+	}
+	return "", false
+}
+
+// Response builds the answer sent for a blocked query.
+func (m *Manager) Response(req *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	reply.RecursionAvailable = true
+	if m.response == ResponseNXDomain {
+		reply.Rcode = dns.RcodeNameError
+		return reply
+	}
+	q := req.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: blockedTTL}
+	switch {
+	case q.Qtype == dns.TypeA && m.ip == nil:
+		reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: net.IPv4zero})
+	case q.Qtype == dns.TypeAAAA && m.ip == nil:
+		reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.IPv6unspecified})
+	case q.Qtype == dns.TypeA && m.ip.To4() != nil:
+		reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: m.ip.To4()})
+	case q.Qtype == dns.TypeAAAA && m.ip.To4() == nil:
+		reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr, AAAA: m.ip})
+	}
+	return reply
+}
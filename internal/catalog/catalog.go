@@ -0,0 +1,193 @@
+package catalog
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FilteringNone    = "none"
+	FilteringMalware = "malware"
+	FilteringFamily  = "family"
+	FilteringAds     = "ads"
+)
+
+//go:embed default.yaml
+var defaultCatalog []byte
+
+// Resolver is one public or private resolver service known to cDNS.
+type Resolver struct {
+	ID        string   `yaml:"id" json:"id"`
+	Name      string   `yaml:"name" json:"name"`
+	Provider  string   `yaml:"provider" json:"provider"`
+	IPv4      []string `yaml:"ipv4" json:"ipv4,omitempty"`
+	IPv6      []string `yaml:"ipv6" json:"ipv6,omitempty"`
+	DoT       []string `yaml:"dot" json:"dot,omitempty"`
+	DoH       []string `yaml:"doh" json:"doh,omitempty"`
+	DoQ       []string `yaml:"doq" json:"doq,omitempty"`
+	Filtering string   `yaml:"filtering" json:"filtering"`
+	DNSSEC    bool     `yaml:"dnssec" json:"dnssec"`
+	Tags      []string `yaml:"tags" json:"tags,omitempty"`
+}
+
+// Addresses returns the plain DNS addresses of the resolver, IPv4 first.
+func (r Resolver) Addresses() []string {
+	addresses := make([]string, 0, len(r.IPv4)+len(r.IPv6))
+	addresses = append(addresses, r.IPv4...)
+	return append(addresses, r.IPv6...)
+}
+
+func (r Resolver) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return strings.EqualFold(r.Filtering, tag)
+}
+
+type Catalog struct {
+	Resolvers []Resolver `yaml:"resolvers" json:"resolvers"`
+}
+
+var (
+	current *Catalog
+	mutex   sync.Mutex
+)
+
+// Init loads the embedded catalog merged with the user catalog at path. An
+// empty path uses cdns/catalog.yaml in the user configuration directory when
+// that file exists.
+func Init(path string) error {
+	c, err := Load(path)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	current = c
+	mutex.Unlock()
+	return nil
+}
+
+// Get returns the catalog set by Init, or the embedded one.
+func Get() *Catalog {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if current == nil {
+		c, err := parse(defaultCatalog)
+		if err != nil {
+			panic(fmt.Sprintf("invalid embedded resolver catalog: %v", err))
+		}
+		current = c
+	}
+	return current
+}
+
+func Load(path string) (*Catalog, error) {
+	c, err := parse(defaultCatalog)
+	if err != nil {
+		return nil, fmt.Errorf("embedded catalog: %v", err)
+	}
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return c, nil
+		}
+		path = filepath.Join(dir, "cdns", "catalog.yaml")
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return c, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	user, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	c.merge(user)
+	return c, nil
+}
+
+// parse decodes a YAML catalog. JSON files are accepted since JSON is a subset
+// of YAML.
+func parse(data []byte) (*Catalog, error) {
+	c := new(Catalog)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	for i, r := range c.Resolvers {
+		if r.ID == "" {
+			return nil, fmt.Errorf("resolver %d has no id", i+1)
+		}
+		if r.Filtering == "" {
+			c.Resolvers[i].Filtering = FilteringNone
+		}
+	}
+	return c, nil
+}
+
+func (c *Catalog) merge(other *Catalog) {
+	for _, r := range other.Resolvers {
+		replaced := false
+		for i := range c.Resolvers {
+			if c.Resolvers[i].ID == r.ID {
+				c.Resolvers[i] = r
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.Resolvers = append(c.Resolvers, r)
+		}
+	}
+}
+
+func (c *Catalog) Find(id string) (Resolver, bool) {
+	for _, r := range c.Resolvers {
+		if strings.EqualFold(r.ID, id) {
+			return r, true
+		}
+	}
+	return Resolver{}, false
+}
+
+// Filter returns the resolvers carrying every one of tags. Filtering policies
+// count as tags, so "malware" selects malware-blocking resolvers.
+func (c *Catalog) Filter(tags []string) []Resolver {
+	var resolvers []Resolver
+	for _, r := range c.Resolvers {
+		matches := true
+		for _, tag := range tags {
+			if !r.HasTag(tag) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			resolvers = append(resolvers, r)
+		}
+	}
+	return resolvers
+}
+
+// NameFor returns the display name of the resolver owning address.
+func (c *Catalog) NameFor(address string) string {
+	for _, r := range c.Resolvers {
+		for _, a := range r.Addresses() {
+			if a == address {
+				return r.Name
+			}
+		}
+	}
+	return ""
+}
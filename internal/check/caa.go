@@ -0,0 +1,342 @@
+package check
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// caaCritical is the issuer critical flag of RFC 8659 section 4.1.
+const caaCritical = 128
+
+// Property tags a CA is expected to understand. Unknown tags only matter
+// when they carry the critical flag.
+var knownCAATags = map[string]bool{
+	"issue":        true,
+	"issuewild":    true,
+	"iodef":        true,
+	"issuemail":    true,
+	"contactemail": true,
+	"contactphone": true,
+}
+
+// CAAReport describes the relevant CAA record set of a name and which CAs it
+// authorizes.
+type CAAReport struct {
+	Name            string         `json:"name"`
+	CA              string         `json:"ca,omitempty"`
+	Lookups         []CAALookup    `json:"lookups"`
+	RelevantName    string         `json:"relevant_name,omitempty"`
+	Properties      []CAAProperty  `json:"properties,omitempty"`
+	Issuers         []string       `json:"issuers"`
+	WildcardIssuers []string       `json:"wildcard_issuers"`
+	Issue           *CAAVerdict    `json:"issue,omitempty"`
+	IssueWild       *CAAVerdict    `json:"issuewild,omitempty"`
+	Findings        []Finding      `json:"findings"`
+	Summary         map[string]int `json:"summary"`
+}
+
+// CAALookup is one step of the tree climb, with any CNAMEs followed.
+type CAALookup struct {
+	Name    string   `json:"name"`
+	CNAMEs  []string `json:"cnames,omitempty"`
+	Rcode   string   `json:"rcode"`
+	Records int      `json:"records"`
+}
+
+type CAAProperty struct {
+	Flag       uint8             `json:"flag"`
+	Tag        string            `json:"tag"`
+	Value      string            `json:"value"`
+	Issuer     string            `json:"issuer,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Malformed  bool              `json:"malformed,omitempty"`
+}
+
+type CAAVerdict struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+type caaChecker struct {
+	querier
+	resolver string
+	report   *CAAReport
+}
+
+func RunCAA(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	name, err := ldns.ToASCII(strings.TrimPrefix(args[0], "*."))
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	name = dns.Fqdn(name)
+	if err := ldns.ValidateDomain(name, ldns.ModeHostname); err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	resolver, err := resolverFromFlags(cmd, cfg)
+	if err != nil {
+		logger.GetLogger().Fatal("No resolver available", zap.Error(err))
+	}
+	ca, _ := cmd.Flags().GetString("ca")
+
+	logger.GetLogger().Info("Evaluating CAA policy", zap.String("name", name), zap.String("ca", ca))
+	report := CAA(name, strings.ToLower(ca), resolver, cfg)
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printCAAReport(report)
+	}
+	if Failed(report.Findings) {
+		os.Exit(1)
+	}
+}
+
+// CAA finds the relevant CAA record set of name following RFC 8659 section 3:
+// the resolver follows CNAMEs, and when name has no CAA records its parents
+// are tried up to, but excluding, the root. When ca is set, the report says
+// whether that CA may issue certificates for name and *.name.
+func CAA(name, ca, resolver string, cfg config.Config) *CAAReport {
+	c := &caaChecker{
+		querier:  querier{cfg: cfg},
+		resolver: resolver,
+		report:   &CAAReport{Name: name, CA: ca},
+	}
+	if c.climb() {
+		c.evaluate()
+	}
+	Rank(c.report.Findings)
+	c.report.Summary = Summarize(c.report.Findings)
+	return c.report
+}
+
+func (c *caaChecker) add(check string, severity Severity, format string, args ...interface{}) {
+	c.report.Findings = append(c.report.Findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// climb looks up CAA records from the name towards the top-level domain and
+// stores the first non-empty set. It returns false when a lookup failed,
+// since CAs must then refuse to issue.
+func (c *caaChecker) climb() bool {
+	for name := c.report.Name; name != "."; name = parentZone(name) {
+		r, err := c.ask(c.resolver, name, dns.TypeCAA, true)
+		if err != nil {
+			c.add("lookup", SeverityError, "CAA lookup for %s failed (%v), CAs must not issue", name, err)
+			return false
+		}
+		lookup := CAALookup{Name: name, Rcode: dns.RcodeToString[r.Rcode]}
+		owner := name
+		for {
+			hop, ok := cnameTarget(r.Answer, owner)
+			if !ok {
+				break
+			}
+			lookup.CNAMEs = append(lookup.CNAMEs, hop)
+			owner = hop
+			if len(lookup.CNAMEs) > ldns.MaxChainLength {
+				break
+			}
+		}
+		var properties []CAAProperty
+		for _, rr := range r.Answer {
+			if caa, ok := rr.(*dns.CAA); ok && strings.EqualFold(caa.Hdr.Name, owner) {
+				properties = append(properties, parseCAA(caa))
+			}
+		}
+		lookup.Records = len(properties)
+		c.report.Lookups = append(c.report.Lookups, lookup)
+		if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			c.add("lookup", SeverityError, "CAA lookup for %s answered %s, CAs must not issue", name, lookup.Rcode)
+			return false
+		}
+		if len(properties) > 0 {
+			c.report.RelevantName = name
+			c.report.Properties = properties
+			return true
+		}
+	}
+	return true
+}
+
+func (c *caaChecker) evaluate() {
+	report := c.report
+	if report.RelevantName == "" {
+		c.add("caa", SeverityInfo, "no CAA records for %s or its parents, any CA may issue", report.Name)
+		if report.CA != "" {
+			report.Issue = &CAAVerdict{Allowed: true, Reason: "no CAA records"}
+			report.IssueWild = &CAAVerdict{Allowed: true, Reason: "no CAA records"}
+			c.add("issue", SeverityOK, "%s may issue certificates for %s and *.%s", report.CA, report.Name, report.Name)
+		}
+		return
+	}
+
+	var issue, issuewild []CAAProperty
+	critical := ""
+	for _, p := range report.Properties {
+		switch {
+		case p.Tag == "issue":
+			issue = append(issue, p)
+		case p.Tag == "issuewild":
+			issuewild = append(issuewild, p)
+		case p.Tag == "iodef":
+			if !strings.HasPrefix(p.Value, "mailto:") && !strings.HasPrefix(p.Value, "https://") && !strings.HasPrefix(p.Value, "http://") {
+				c.add("iodef", SeverityWarning, "iodef %q must be a mailto:, http: or https: URL", p.Value)
+			}
+		case !knownCAATags[p.Tag] && p.Flag&caaCritical != 0:
+			critical = p.Tag
+			c.add("caa", SeverityError, "unknown property %q is marked critical, CAs that do not understand it must not issue", p.Tag)
+		}
+		if p.Malformed {
+			c.add("caa", SeverityWarning, "%s value %q is malformed and authorizes no CA", p.Tag, p.Value)
+		}
+	}
+	if len(issuewild) == 0 {
+		issuewild = issue
+	}
+	report.Issuers = issuers(issue)
+	report.WildcardIssuers = issuers(issuewild)
+	if report.CA == "" {
+		return
+	}
+
+	report.Issue = verdict(report.CA, issue, critical, "issue")
+	report.IssueWild = verdict(report.CA, issuewild, critical, "issuewild")
+	if report.Issue.Allowed {
+		c.add("issue", SeverityOK, "%s may issue certificates for %s: %s", report.CA, report.Name, report.Issue.Reason)
+	} else {
+		c.add("issue", SeverityError, "%s may not issue certificates for %s: %s", report.CA, report.Name, report.Issue.Reason)
+	}
+	if report.IssueWild.Allowed {
+		c.add("issuewild", SeverityOK, "%s may issue wildcard certificates for *.%s: %s", report.CA, report.Name, report.IssueWild.Reason)
+	} else {
+		c.add("issuewild", SeverityWarning, "%s may not issue wildcard certificates for *.%s: %s", report.CA, report.Name, report.IssueWild.Reason)
+	}
+}
+
+// verdict applies one set of issue or issuewild properties to ca. An empty
+// set leaves issuance unrestricted.
+func verdict(ca string, properties []CAAProperty, critical, tag string) *CAAVerdict {
+	if critical != "" {
+		return &CAAVerdict{Reason: fmt.Sprintf("unknown critical property %q", critical)}
+	}
+	if len(properties) == 0 {
+		return &CAAVerdict{Allowed: true, Reason: "no issue properties restrict issuance"}
+	}
+	for _, p := range properties {
+		if p.Issuer == ca {
+			return &CAAVerdict{Allowed: true, Reason: fmt.Sprintf("authorized by %s %q", p.Tag, p.Value)}
+		}
+	}
+	return &CAAVerdict{Reason: fmt.Sprintf("not listed in %s properties", tag)}
+}
+
+// issuers lists the CAs authorized by properties; nil means any CA.
+func issuers(properties []CAAProperty) []string {
+	if len(properties) == 0 {
+		return nil
+	}
+	list := []string{}
+	for _, p := range properties {
+		if p.Issuer != "" {
+			list = append(list, p.Issuer)
+		}
+	}
+	return list
+}
+
+// parseCAA splits an issue or issuewild value into the issuer domain and its
+// parameters (RFC 8659 section 4.2). Values that do not follow the grammar
+// authorize no CA.
+func parseCAA(rr *dns.CAA) CAAProperty {
+	p := CAAProperty{Flag: rr.Flag, Tag: strings.ToLower(rr.Tag), Value: rr.Value}
+	if p.Tag != "issue" && p.Tag != "issuewild" {
+		return p
+	}
+	parts := strings.Split(rr.Value, ";")
+	issuer := strings.ToLower(strings.TrimSpace(parts[0]))
+	if issuer != "" {
+		if _, ok := dns.IsDomainName(issuer); !ok || strings.ContainsAny(issuer, " _*") || strings.HasSuffix(issuer, ".") {
+			p.Malformed = true
+			return p
+		}
+		p.Issuer = issuer
+	}
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		key, value, found := strings.Cut(param, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			p.Malformed = true
+			p.Issuer = ""
+			return p
+		}
+		if p.Parameters == nil {
+			p.Parameters = make(map[string]string)
+		}
+		p.Parameters[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return p
+}
+
+func cnameTarget(rrs []dns.RR, name string) (string, bool) {
+	for _, rr := range rrs {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+			return cname.Target, true
+		}
+	}
+	return "", false
+}
+
+func printCAAReport(report *CAAReport) {
+	fmt.Printf("\n🔏 CAA policy for %s\n", report.Name)
+	for _, lookup := range report.Lookups {
+		fmt.Printf("  %s", lookup.Name)
+		for _, cname := range lookup.CNAMEs {
+			fmt.Printf(" → %s", cname)
+		}
+		fmt.Printf(": %s, %d CAA records\n", lookup.Rcode, lookup.Records)
+	}
+	if report.RelevantName != "" {
+		fmt.Printf("\n  Relevant set at %s:\n", report.RelevantName)
+		for _, p := range report.Properties {
+			fmt.Printf("    %d %s %q\n", p.Flag, p.Tag, p.Value)
+		}
+		fmt.Printf("  Issuers:          %s\n", issuerList(report.Issuers))
+		fmt.Printf("  Wildcard issuers: %s\n", issuerList(report.WildcardIssuers))
+	}
+	fmt.Println()
+	printFindings(report.Findings)
+}
+
+func issuerList(list []string) string {
+	switch {
+	case list == nil:
+		return "any CA"
+	case len(list) == 0:
+		return "none"
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,408 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+	"cDNS/internal/zone"
+)
+
+// Drift statuses of an RRset.
+const (
+	DriftMissing   = "missing"
+	DriftExtra     = "extra"
+	DriftDifferent = "different"
+	DriftTTL       = "ttl"
+	DriftError     = "error"
+	DriftInSync    = "in_sync"
+)
+
+// DriftReport compares a zone file with what its nameservers serve.
+type DriftReport struct {
+	Zone        string         `json:"zone"`
+	File        string         `json:"file"`
+	Serial      uint32         `json:"serial"`
+	RRsets      int            `json:"rrsets"`
+	Nameservers []DriftServer  `json:"nameservers"`
+	Summary     map[string]int `json:"summary"`
+}
+
+// DriftServer is the comparison against one nameserver address. With
+// --axfr the live zone comes from a transfer, which also reveals RRsets
+// missing from the file; otherwise every RRset of the file is queried.
+type DriftServer struct {
+	Nameserver    string       `json:"nameserver"`
+	Method        string       `json:"method"`
+	TransferError string       `json:"transfer_error,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	InSync        int          `json:"in_sync"`
+	Drift         []RRsetDrift `json:"drift,omitempty"`
+}
+
+// RRsetDrift lists how one RRset served live differs from the file. Missing
+// records are only in the file, Extra ones only live.
+type RRsetDrift struct {
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Status  string     `json:"status"`
+	Missing []string   `json:"missing,omitempty"`
+	Extra   []string   `json:"extra,omitempty"`
+	TTL     []TTLDrift `json:"ttl,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// TTLDrift is a record served with a different TTL than in the file.
+type TTLDrift struct {
+	File    string `json:"file"`
+	Live    string `json:"live"`
+	FileTTL uint32 `json:"file_ttl"`
+	LiveTTL uint32 `json:"live_ttl"`
+}
+
+type rrsetKey struct {
+	name  string
+	rtype uint16
+}
+
+type driftChecker struct {
+	querier
+	zone   *zone.Zone
+	file   map[rrsetKey][]dns.RR
+	keys   []rrsetKey
+	report *DriftReport
+}
+
+func RunDrift(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	spec, _ := cmd.Flags().GetString("zone")
+	nameservers, _ := cmd.Flags().GetStringSlice("ns")
+	axfr, _ := cmd.Flags().GetBool("axfr")
+	origin, path := driftZoneSpec(spec)
+	z, err := zone.Load(origin, path)
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to load zone", zap.String("path", path), zap.Error(err))
+	}
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	servers := driftServers(z, nameservers, cfg)
+	if len(servers) == 0 {
+		logger.GetLogger().Fatal("No valid nameservers provided")
+	}
+
+	logger.GetLogger().Info("Comparing zone file with nameservers", zap.String("zone", z.Origin), zap.Int("nameservers", len(servers)))
+	report := Drift(z, servers, axfr, cfg)
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printDriftReport(report)
+	}
+	if report.Summary[DriftInSync] != report.RRsets*len(report.Nameservers) || report.Summary[DriftExtra] > 0 {
+		os.Exit(1)
+	}
+}
+
+// driftZoneSpec accepts "origin=path" like serve, or a path whose file name
+// gives the origin, e.g. example.com.zone, example.com.db or db.example.com.
+func driftZoneSpec(spec string) (string, string) {
+	if origin, path, err := zone.ParseSpec(spec); err == nil {
+		return origin, path
+	}
+	origin := filepath.Base(spec)
+	for _, suffix := range []string{".zone", ".db", ".txt"} {
+		origin = strings.TrimSuffix(origin, suffix)
+	}
+	return dns.Fqdn(strings.TrimPrefix(origin, "db.")), spec
+}
+
+// driftServers turns nameserver arguments into addresses. Names without a
+// dot are relative to the zone, and in-zone names use the addresses of the
+// file. Without arguments the apex NS records of the file are used.
+func driftServers(z *zone.Zone, nameservers []string, cfg config.Config) []server {
+	if len(nameservers) == 0 {
+		nameservers = nsNames(z.Records()[z.Origin][dns.TypeNS], z.Origin)
+	}
+	var servers []server
+	for _, ns := range nameservers {
+		host := ns
+		if h, _, err := net.SplitHostPort(ns); err == nil {
+			host = h
+		}
+		if net.ParseIP(strings.Trim(host, "[]")) != nil || strings.Contains(ns, "://") || strings.HasPrefix(ns, "@") {
+			for _, address := range ldns.PrepareNameservers([]string{ns}, cfg.IPVersion) {
+				servers = append(servers, server{name: ns, address: address})
+			}
+			continue
+		}
+		name := dns.CanonicalName(ns)
+		if !strings.Contains(strings.TrimSuffix(ns, "."), ".") {
+			name = dns.CanonicalName(ns + "." + z.Origin)
+		}
+		var glue []dns.RR
+		for _, rtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if (rtype == dns.TypeA && cfg.IPVersion == 6) || (rtype == dns.TypeAAAA && cfg.IPVersion == 4) {
+				continue
+			}
+			glue = append(glue, z.Records()[name][rtype]...)
+		}
+		addrs := addresses(glue, name)
+		if len(addrs) == 0 {
+			addrs = []string{strings.TrimSuffix(name, ".")}
+		}
+		for _, addr := range addrs {
+			for _, address := range ldns.PrepareNameservers([]string{net.JoinHostPort(addr, "53")}, cfg.IPVersion) {
+				servers = append(servers, server{name: name, address: address})
+			}
+		}
+	}
+	return servers
+}
+
+// Drift compares every RRset of z, except signatures, with each server.
+func Drift(z *zone.Zone, servers []server, axfr bool, cfg config.Config) *DriftReport {
+	c := &driftChecker{
+		querier: querier{cfg: cfg},
+		zone:    z,
+		file:    make(map[rrsetKey][]dns.RR),
+		report: &DriftReport{
+			Zone:    z.Origin,
+			File:    z.Path,
+			Serial:  z.SOA.Serial,
+			Summary: make(map[string]int),
+		},
+	}
+	for name, types := range z.Records() {
+		for rtype, rrs := range types {
+			if rtype == dns.TypeRRSIG {
+				continue
+			}
+			key := rrsetKey{name, rtype}
+			c.file[key] = rrs
+			c.keys = append(c.keys, key)
+		}
+	}
+	sortRRsetKeys(c.keys)
+	c.report.RRsets = len(c.keys)
+
+	for _, s := range servers {
+		result := c.compare(s, axfr)
+		c.report.Summary[DriftInSync] += result.InSync
+		for _, d := range result.Drift {
+			c.report.Summary[d.Status]++
+		}
+		if result.Error != "" {
+			c.report.Summary[DriftError] += len(c.keys)
+		}
+		c.report.Nameservers = append(c.report.Nameservers, result)
+	}
+	return c.report
+}
+
+func (c *driftChecker) compare(s server, axfr bool) DriftServer {
+	result := DriftServer{Nameserver: s.String(), Method: "query"}
+	if axfr {
+		rrs, err := ldns.Transfer(c.zone.Origin, s.address, c.cfg)
+		if err == nil {
+			result.Method = "axfr"
+			c.compareTransfer(&result, rrs)
+			return result
+		}
+		result.TransferError = err.Error()
+	}
+
+	r, err := c.ask(s.address, c.zone.Origin, dns.TypeSOA, false)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !r.Authoritative || soaRecord(r.Answer, c.zone.Origin) == nil {
+		result.Error = fmt.Sprintf("not authoritative for %s (%s)", c.zone.Origin, dns.RcodeToString[r.Rcode])
+		return result
+	}
+	for _, key := range c.keys {
+		r, err := c.ask(s.address, key.name, key.rtype, false)
+		if err != nil {
+			result.Drift = append(result.Drift, RRsetDrift{
+				Name:   key.name,
+				Type:   dns.TypeToString[key.rtype],
+				Status: DriftError,
+				Error:  err.Error(),
+			})
+			continue
+		}
+		// Delegations and glue come back in the authority and additional
+		// sections, so every section is searched for the RRset.
+		var live []dns.RR
+		for _, section := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+			for _, rr := range section {
+				if rr.Header().Rrtype == key.rtype && dns.CanonicalName(rr.Header().Name) == key.name {
+					live = append(live, rr)
+				}
+			}
+		}
+		c.record(&result, key, c.file[key], live)
+	}
+	return result
+}
+
+// compareTransfer compares the RRsets of a zone transfer, whose SOA is
+// repeated at the end, with the file.
+func (c *driftChecker) compareTransfer(result *DriftServer, rrs []dns.RR) {
+	live := make(map[rrsetKey][]dns.RR)
+	if len(rrs) > 1 {
+		rrs = rrs[:len(rrs)-1]
+	}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		live[key] = append(live[key], rr)
+	}
+	for _, key := range c.keys {
+		c.record(result, key, c.file[key], live[key])
+	}
+	var extra []rrsetKey
+	for key := range live {
+		if _, ok := c.file[key]; !ok {
+			extra = append(extra, key)
+		}
+	}
+	sortRRsetKeys(extra)
+	for _, key := range extra {
+		c.record(result, key, nil, live[key])
+	}
+}
+
+func (c *driftChecker) record(result *DriftServer, key rrsetKey, file, live []dns.RR) {
+	d := RRsetDrift{Name: key.name, Type: dns.TypeToString[key.rtype]}
+	liveByData := make(map[string]dns.RR)
+	for _, rr := range live {
+		liveByData[rdataKey(rr)] = rr
+	}
+	fileByData := make(map[string]dns.RR)
+	for _, rr := range file {
+		data := rdataKey(rr)
+		fileByData[data] = rr
+		liveRR, ok := liveByData[data]
+		switch {
+		case !ok:
+			d.Missing = append(d.Missing, rr.String())
+		case liveRR.Header().Ttl != rr.Header().Ttl:
+			d.TTL = append(d.TTL, TTLDrift{
+				File:    rr.String(),
+				Live:    liveRR.String(),
+				FileTTL: rr.Header().Ttl,
+				LiveTTL: liveRR.Header().Ttl,
+			})
+		}
+	}
+	for data, rr := range liveByData {
+		if _, ok := fileByData[data]; !ok {
+			d.Extra = append(d.Extra, rr.String())
+		}
+	}
+	sort.Strings(d.Missing)
+	sort.Strings(d.Extra)
+
+	switch {
+	case len(file) == 0:
+		d.Status = DriftExtra
+	case len(live) == 0:
+		d.Status = DriftMissing
+	case len(d.Missing) > 0 || len(d.Extra) > 0:
+		d.Status = DriftDifferent
+	case len(d.TTL) > 0:
+		d.Status = DriftTTL
+	default:
+		result.InSync++
+		return
+	}
+	result.Drift = append(result.Drift, d)
+}
+
+// rdataKey identifies a record by owner, type and data, ignoring its TTL.
+func rdataKey(rr dns.RR) string {
+	rr = dns.Copy(rr)
+	rr.Header().Ttl = 0
+	rr.Header().Name = dns.CanonicalName(rr.Header().Name)
+	return rr.String()
+}
+
+// sortRRsetKeys orders RRsets by owner name from the apex down, then type.
+func sortRRsetKeys(keys []rrsetKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return reversedLabels(keys[i].name) < reversedLabels(keys[j].name)
+		}
+		return keys[i].rtype < keys[j].rtype
+	})
+}
+
+func reversedLabels(name string) string {
+	labels := dns.SplitDomainName(name)
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, "\x00")
+}
+
+// printDriftReport writes the drift as a unified-diff-like patch from the
+// file to each nameserver, followed by a summary on stderr.
+func printDriftReport(report *DriftReport) {
+	for _, ns := range report.Nameservers {
+		fmt.Printf("--- %s\t(serial %d)\n", report.File, report.Serial)
+		fmt.Printf("+++ %s\t(%s)\n", ns.Nameserver, ns.Method)
+		if ns.Error != "" {
+			fmt.Printf("@@ error: %s @@\n", ns.Error)
+			continue
+		}
+		for _, d := range ns.Drift {
+			fmt.Printf("@@ %s %s %s @@\n", d.Name, d.Type, d.Status)
+			if d.Error != "" {
+				fmt.Printf(" ; %s\n", d.Error)
+			}
+			for _, rr := range d.Missing {
+				fmt.Printf("-%s\n", rr)
+			}
+			for _, rr := range d.Extra {
+				fmt.Printf("+%s\n", rr)
+			}
+			for _, t := range d.TTL {
+				fmt.Printf("-%s\n+%s\n", t.File, t.Live)
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\n🔀 Drift of %s (%d RRsets):\n", report.Zone, report.RRsets)
+	for _, ns := range report.Nameservers {
+		switch {
+		case ns.Error != "":
+			fmt.Fprintf(os.Stderr, "  ❌ %s: %s\n", ns.Nameserver, ns.Error)
+		case len(ns.Drift) == 0:
+			fmt.Fprintf(os.Stderr, "  ✅ %s: in sync\n", ns.Nameserver)
+		default:
+			fmt.Fprintf(os.Stderr, "  ⚠️  %s: %d RRsets drifted, %d in sync\n", ns.Nameserver, len(ns.Drift), ns.InSync)
+		}
+		if ns.TransferError != "" {
+			fmt.Fprintf(os.Stderr, "     zone transfer failed, queried instead: %s\n", ns.TransferError)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"cDNS/internal/config"
+	"cDNS/internal/logger"
+)
+
+// Severity ranks findings; higher values are worse.
+type Severity int
+
+const (
+	SeverityOK Severity = iota
+	SeverityInfo
+	SeverityWarning
+	SeverityError
+)
+
+var severityNames = map[Severity]string{
+	SeverityOK:      "ok",
+	SeverityInfo:    "info",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+var severityIcons = map[Severity]string{
+	SeverityOK:      "✅",
+	SeverityInfo:    "ℹ️ ",
+	SeverityWarning: "⚠️ ",
+	SeverityError:   "❌",
+}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Finding is the outcome of a single check, optionally tied to one server.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Server   string   `json:"server,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Rank orders findings from most to least severe, keeping the order in which
+// checks ran within each severity.
+func Rank(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+}
+
+// Summarize counts findings per severity name.
+func Summarize(findings []Finding) map[string]int {
+	summary := make(map[string]int)
+	for _, f := range findings {
+		summary[f.Severity.String()]++
+	}
+	return summary
+}
+
+// Failed reports whether any finding is an error.
+func Failed(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func printFindings(findings []Finding) {
+	for _, f := range findings {
+		fmt.Printf("  %s %-7s %-13s ", severityIcons[f.Severity], f.Severity, f.Check)
+		if f.Server != "" {
+			fmt.Printf("%s: ", f.Server)
+		}
+		fmt.Println(f.Message)
+	}
+	summary := Summarize(findings)
+	fmt.Printf("\n📋 Summary: errors %d, warnings %d, info %d, ok %d\n",
+		summary["error"], summary["warning"], summary["info"], summary["ok"])
+}
+
+func jsonOutput(v interface{}, cfg config.Config) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to marshal report", zap.Error(err))
+	}
+	if cfg.OutputFile != "" {
+		if err := os.WriteFile(cfg.OutputFile, out, 0644); err != nil {
+			logger.GetLogger().Fatal("Failed to write output file", zap.Error(err))
+		}
+		fmt.Printf("Report written to: %s\n", cfg.OutputFile)
+		return
+	}
+	fmt.Println(string(out))
+}
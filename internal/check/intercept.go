@@ -0,0 +1,322 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// DefaultEncryptedResolvers are compared with plain DNS sent to the same
+// address, which a middlebox can answer in their place.
+var DefaultEncryptedResolvers = []string{"tls://1.1.1.1", "tls://8.8.8.8"}
+
+// DefaultUnroutable are documentation addresses (RFC 5737, RFC 3849) no DNS
+// server listens on, so any answer comes from a device on the path.
+var DefaultUnroutable = []string{"192.0.2.1", "198.51.100.1", "203.0.113.1", "2001:db8::1"}
+
+// InterceptReport collects the evidence of DNS interception on the path to
+// the internet.
+type InterceptReport struct {
+	Resolver    string                `json:"resolver"`
+	Name        string                `json:"name"`
+	Whoami      string                `json:"whoami"`
+	Comparisons []InterceptComparison `json:"comparisons,omitempty"`
+	Findings    []Finding             `json:"findings"`
+	Summary     map[string]int        `json:"summary"`
+}
+
+// InterceptComparison holds the answers of one resolver over an encrypted
+// transport and over plain DNS.
+type InterceptComparison struct {
+	Check            string   `json:"check"`
+	Encrypted        string   `json:"encrypted"`
+	Plain            string   `json:"plain"`
+	EncryptedAnswers []string `json:"encrypted_answers"`
+	PlainAnswers     []string `json:"plain_answers"`
+}
+
+type interceptChecker struct {
+	querier
+	report *InterceptReport
+}
+
+func RunInterceptCheck(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	resolver, err := resolverFromFlags(cmd, cfg)
+	if err != nil {
+		logger.GetLogger().Fatal("No resolver available", zap.Error(err))
+	}
+	var names []string
+	for _, flag := range []string{"name", "whoami"} {
+		value, _ := cmd.Flags().GetString(flag)
+		name, err := ldns.ToASCII(value)
+		if err == nil {
+			name = dns.Fqdn(name)
+			err = ldns.ValidateDomain(name, ldns.ModeDNSName)
+		}
+		if err != nil {
+			logger.GetLogger().Fatal("Invalid name", zap.String("flag", flag), zap.Error(err))
+		}
+		names = append(names, name)
+	}
+	encrypted, _ := cmd.Flags().GetStringSlice("encrypted")
+	for _, ns := range encrypted {
+		ep, err := ldns.ParseEndpoint(ns)
+		if err != nil || (ep.Transport != "tls" && ep.Transport != "https") {
+			logger.GetLogger().Fatal("Encrypted resolvers must be tls:// or https:// endpoints", zap.String("resolver", ns))
+		}
+	}
+	unroutable, _ := cmd.Flags().GetStringSlice("unroutable")
+	unroutable = ldns.PrepareNameservers(unroutable, cfg.IPVersion)
+
+	logger.GetLogger().Info("Checking for DNS interception", zap.String("resolver", resolver), zap.Strings("encrypted", encrypted))
+	report := InterceptCheck(resolver, names[0], names[1], encrypted, unroutable, cfg)
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printInterceptReport(report)
+	}
+	if Failed(report.Findings) {
+		os.Exit(1)
+	}
+}
+
+// InterceptCheck looks for a middlebox answering DNS on port 53: answers from
+// unroutable addresses, plain answers differing from those the same
+// resolvers give over encrypted transports, and NXDOMAIN answers rewritten
+// by resolver or by the path to the encrypted resolvers' plain addresses.
+func InterceptCheck(resolver, name, whoami string, encrypted, unroutable []string, cfg config.Config) *InterceptReport {
+	c := &interceptChecker{
+		querier: querier{cfg: cfg},
+		report:  &InterceptReport{Resolver: resolver, Name: name, Whoami: whoami},
+	}
+	c.checkUnroutable(unroutable)
+	plain := []string{resolver}
+	for _, ns := range encrypted {
+		twin := plainAddress(ns)
+		c.compare("answer", ns, twin, name, dns.TypeA)
+		c.compare("whoami", ns, twin, whoami, dns.TypeTXT)
+		if !slices.Contains(plain, twin) {
+			plain = append(plain, twin)
+		}
+	}
+	for _, ns := range plain {
+		c.checkNXDomain(ns)
+	}
+	Rank(c.report.Findings)
+	c.report.Summary = Summarize(c.report.Findings)
+	return c.report
+}
+
+func (c *interceptChecker) add(check string, severity Severity, server, format string, args ...interface{}) {
+	c.report.Findings = append(c.report.Findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Server:   server,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkUnroutable queries every address in parallel with a single attempt,
+// since each one is expected to time out.
+func (c *interceptChecker) checkUnroutable(addresses []string) {
+	once := c.querier
+	once.cfg.Retries = 1
+	answered := make([]*dns.Msg, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			answered[i], _ = once.ask(address, c.report.Name, dns.TypeA, true)
+		}(i, address)
+	}
+	wg.Wait()
+
+	silent := 0
+	for i, r := range answered {
+		if r == nil {
+			silent++
+			continue
+		}
+		c.add("unroutable", SeverityError, addresses[i], "answered %s with %s although no server exists there, a middlebox intercepts port 53",
+			c.report.Name, describeAnswer(r))
+	}
+	if silent > 0 && silent == len(addresses) {
+		c.add("unroutable", SeverityOK, "", "no answer from %d unroutable addresses", silent)
+	}
+}
+
+// compare queries name from encrypted and from its plain address.
+func (c *interceptChecker) compare(check, encrypted, plain, name string, qtype uint16) {
+	comparison := InterceptComparison{Check: check, Encrypted: encrypted, Plain: plain}
+	secure, err := c.ask(encrypted, name, qtype, true)
+	if err != nil {
+		c.add(check, SeverityWarning, encrypted, "encrypted query failed while checking %s: %v (blocked encrypted DNS is itself a sign of interference)", name, err)
+		return
+	}
+	cleartext, err := c.ask(plain, name, qtype, true)
+	if err != nil {
+		c.add(check, SeverityWarning, plain, "plain query for %s failed: %v", name, err)
+		return
+	}
+	comparison.EncryptedAnswers = answerData(secure.Answer, qtype)
+	comparison.PlainAnswers = answerData(cleartext.Answer, qtype)
+	c.report.Comparisons = append(c.report.Comparisons, comparison)
+
+	if check == "whoami" {
+		c.compareWhoami(comparison)
+		return
+	}
+	if secure.Rcode != cleartext.Rcode || !slices.Equal(comparison.EncryptedAnswers, comparison.PlainAnswers) {
+		c.add(check, SeverityWarning, plain, "plain answer for %s (%s) differs from %s (%s)",
+			name, describeAnswer(cleartext), encrypted, describeAnswer(secure))
+		return
+	}
+	c.add(check, SeverityOK, plain, "plain and encrypted answers for %s match", name)
+}
+
+// compareWhoami checks that the resolver address a whoami service saw is in
+// the same network for both transports. Large resolvers egress from many
+// addresses, so only the network is compared.
+func (c *interceptChecker) compareWhoami(comparison InterceptComparison) {
+	secure, cleartext := whoamiAddress(comparison.EncryptedAnswers), whoamiAddress(comparison.PlainAnswers)
+	switch {
+	case !secure.IsValid():
+		c.add("whoami", SeverityInfo, comparison.Encrypted, "%s returned no address over the encrypted transport", c.report.Whoami)
+	case !cleartext.IsValid():
+		c.add("whoami", SeverityWarning, comparison.Plain, "%s returned no address over plain DNS, the query may not have reached %s", c.report.Whoami, comparison.Plain)
+	case !sameNetwork(secure, cleartext):
+		c.add("whoami", SeverityWarning, comparison.Plain, "plain DNS was resolved from %s but encrypted DNS from %s, another resolver answers port 53", cleartext, secure)
+	default:
+		c.add("whoami", SeverityOK, comparison.Plain, "plain and encrypted queries left from the same network (%s, %s)", cleartext, secure)
+	}
+}
+
+// checkNXDomain queries a random name, which must not exist, through nameserver.
+func (c *interceptChecker) checkNXDomain(nameserver string) {
+	name := ldns.RandomLabel() + "." + c.report.Name
+	r, err := c.ask(nameserver, name, dns.TypeA, true)
+	switch {
+	case err != nil:
+		c.add("nxdomain", SeverityInfo, nameserver, "query failed: %v", err)
+	case r.Rcode == dns.RcodeNameError:
+		c.add("nxdomain", SeverityOK, nameserver, "NXDOMAIN is passed through")
+	case len(r.Answer) > 0:
+		c.add("nxdomain", SeverityError, nameserver, "nonexistent name %s was answered with %s, NXDOMAIN responses are rewritten", name, describeAnswer(r))
+	default:
+		c.add("nxdomain", SeverityWarning, nameserver, "nonexistent name %s returned %s instead of NXDOMAIN", name, dns.RcodeToString[r.Rcode])
+	}
+}
+
+// plainAddress is the port 53 address of the host serving an encrypted
+// endpoint.
+func plainAddress(nameserver string) string {
+	ep, err := ldns.ParseEndpoint(nameserver)
+	if err != nil {
+		return nameserver
+	}
+	host, _, err := net.SplitHostPort(ep.Address)
+	if err != nil {
+		return nameserver
+	}
+	return net.JoinHostPort(host, "53")
+}
+
+// answerData returns the sorted data of the records of type qtype, ignoring
+// TTLs, which legitimately differ between caches.
+func answerData(rrs []dns.RR, qtype uint16) []string {
+	var data []string
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			data = append(data, rr.A.String())
+		case *dns.AAAA:
+			data = append(data, rr.AAAA.String())
+		case *dns.TXT:
+			data = append(data, strings.Join(rr.Txt, ""))
+		default:
+			data = append(data, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	sort.Strings(data)
+	return data
+}
+
+func describeAnswer(r *dns.Msg) string {
+	var data []string
+	for _, rr := range r.Answer {
+		data = append(data, answerData([]dns.RR{rr}, rr.Header().Rrtype)...)
+	}
+	if len(data) == 0 {
+		return dns.RcodeToString[r.Rcode]
+	}
+	return strings.Join(data, ", ")
+}
+
+// whoamiAddress returns the resolver address reported by a whoami TXT
+// answer, skipping the client subnet line some services add.
+func whoamiAddress(txts []string) netip.Addr {
+	for _, txt := range txts {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(txt)); err == nil {
+			return addr.Unmap()
+		}
+	}
+	return netip.Addr{}
+}
+
+// sameNetwork compares IPv4 addresses by /16 and IPv6 addresses by /32.
+func sameNetwork(a, b netip.Addr) bool {
+	if a.Is4() != b.Is4() {
+		return false
+	}
+	bits := 32
+	if a.Is4() {
+		bits = 16
+	}
+	pa, _ := a.Prefix(bits)
+	pb, _ := b.Prefix(bits)
+	return pa == pb
+}
+
+func printInterceptReport(report *InterceptReport) {
+	fmt.Printf("\n🕵️  DNS interception check via %s\n", report.Resolver)
+	for _, cmp := range report.Comparisons {
+		fmt.Printf("  %-7s %s: %s\n", cmp.Check, cmp.Encrypted, answerList(cmp.EncryptedAnswers))
+		fmt.Printf("  %-7s %s: %s\n", "", cmp.Plain, answerList(cmp.PlainAnswers))
+	}
+	fmt.Println()
+	printFindings(report.Findings)
+}
+
+func answerList(answers []string) string {
+	if len(answers) == 0 {
+		return "(no records)"
+	}
+	return strings.Join(answers, ", ")
+}
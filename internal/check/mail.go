@@ -0,0 +1,748 @@
+package check
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/net/publicsuffix"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// SPF evaluation limits from RFC 7208 section 4.6.4.
+const (
+	maxSPFLookups     = 10
+	maxSPFVoidLookups = 2
+)
+
+// DefaultDKIMSelectors are tried when no --selector is given. Selectors
+// cannot be discovered through DNS, so these are common defaults of mail
+// providers and signing software.
+var DefaultDKIMSelectors = []string{
+	"default", "dkim", "mail", "google", "selector1", "selector2",
+	"k1", "k2", "k3", "s1", "s2", "smtp", "mx", "mandrill", "everlytickey1",
+}
+
+var mtaSTSID = regexp.MustCompile(`^[A-Za-z0-9]{1,32}$`)
+
+// MailReport collects the mail authentication records of a domain.
+type MailReport struct {
+	Domain   string         `json:"domain"`
+	MX       []string       `json:"mx,omitempty"`
+	SPF      *SPF           `json:"spf,omitempty"`
+	DMARC    *TagRecord     `json:"dmarc,omitempty"`
+	DKIM     []DKIMKey      `json:"dkim,omitempty"`
+	MTASTS   *TagRecord     `json:"mta_sts,omitempty"`
+	TLSRPT   *TagRecord     `json:"tls_rpt,omitempty"`
+	BIMI     *TagRecord     `json:"bimi,omitempty"`
+	Findings []Finding      `json:"findings"`
+	Summary  map[string]int `json:"summary"`
+}
+
+// TagRecord is a TXT record made of "tag=value" pairs separated by
+// semicolons, as used by DMARC, DKIM, MTA-STS, TLS-RPT and BIMI.
+type TagRecord struct {
+	Name   string            `json:"name"`
+	Record string            `json:"record"`
+	Tags   map[string]string `json:"tags"`
+}
+
+type SPF struct {
+	Record      string   `json:"record"`
+	All         string   `json:"all,omitempty"`
+	Lookups     int      `json:"lookups"`
+	VoidLookups int      `json:"void_lookups"`
+	Includes    []string `json:"includes,omitempty"`
+}
+
+type DKIMKey struct {
+	Selector string `json:"selector"`
+	Name     string `json:"name"`
+	Record   string `json:"record"`
+	KeyType  string `json:"key_type"`
+	Bits     int    `json:"bits,omitempty"`
+	Testing  bool   `json:"testing,omitempty"`
+	Revoked  bool   `json:"revoked,omitempty"`
+}
+
+type mailChecker struct {
+	querier
+	resolver string
+	report   *MailReport
+}
+
+func RunMail(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	domain, err := ldns.ToASCII(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	domain = dns.Fqdn(domain)
+	if err := ldns.ValidateDomain(domain, ldns.ModeHostname); err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	resolver, err := resolverFromFlags(cmd, cfg)
+	if err != nil {
+		logger.GetLogger().Fatal("No resolver available", zap.Error(err))
+	}
+	selectors, _ := cmd.Flags().GetStringSlice("selector")
+	if len(selectors) == 0 {
+		selectors = DefaultDKIMSelectors
+	}
+
+	logger.GetLogger().Info("Checking mail authentication", zap.String("domain", domain), zap.String("resolver", resolver))
+	report := Mail(domain, selectors, resolver, cfg)
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printMailReport(report)
+	}
+	if Failed(report.Findings) {
+		os.Exit(1)
+	}
+}
+
+// Mail checks the SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records of
+// domain, trying each DKIM selector in turn.
+func Mail(domain string, selectors []string, resolver string, cfg config.Config) *MailReport {
+	c := &mailChecker{
+		querier:  querier{cfg: cfg},
+		resolver: resolver,
+		report:   &MailReport{Domain: domain},
+	}
+	c.checkMX()
+	c.checkSPF()
+	c.checkDMARC()
+	c.checkDKIM(selectors)
+	c.checkMTASTS()
+	c.checkTLSRPT()
+	c.checkBIMI()
+	Rank(c.report.Findings)
+	c.report.Summary = Summarize(c.report.Findings)
+	return c.report
+}
+
+func (c *mailChecker) add(check string, severity Severity, format string, args ...interface{}) {
+	c.report.Findings = append(c.report.Findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// txt returns the TXT records at name with their strings joined. A missing
+// name or an empty answer is not an error.
+func (c *mailChecker) txt(name string) ([]string, error) {
+	r, err := c.ask(c.resolver, name, dns.TypeTXT, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("resolver answered %s", dns.RcodeToString[r.Rcode])
+	}
+	var records []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+	return records, nil
+}
+
+// exists reports whether name has records of qtype.
+func (c *mailChecker) exists(name string, qtypes ...uint16) bool {
+	for _, qtype := range qtypes {
+		r, err := c.ask(c.resolver, name, qtype, true)
+		if err == nil && r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tagRecord fetches the TXT record at name starting with the version tag,
+// e.g. "v=DMARC1". It reports problems under check and returns nil when no
+// usable record exists.
+func (c *mailChecker) tagRecord(check, name, version string) *TagRecord {
+	records, err := c.txt(name)
+	if err != nil {
+		c.add(check, SeverityError, "looking up %s: %v", name, err)
+		return nil
+	}
+	var matching []string
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(strings.ReplaceAll(record, " ", "")), strings.ToLower(version)) {
+			matching = append(matching, record)
+		}
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+	if len(matching) > 1 {
+		c.add(check, SeverityError, "%s has %d %s records, receivers ignore all of them when more than one is published", name, len(matching), version)
+		return nil
+	}
+	return &TagRecord{Name: name, Record: matching[0], Tags: parseTags(matching[0])}
+}
+
+func (c *mailChecker) checkMX() {
+	r, err := c.ask(c.resolver, c.report.Domain, dns.TypeMX, true)
+	if err != nil {
+		c.add("mx", SeverityWarning, "looking up MX: %v", err)
+		return
+	}
+	var nullMX bool
+	for _, rr := range r.Answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			c.report.MX = append(c.report.MX, fmt.Sprintf("%d %s", mx.Preference, mx.Mx))
+			nullMX = nullMX || mx.Mx == "."
+		}
+	}
+	switch {
+	case nullMX && len(c.report.MX) > 1:
+		c.add("mx", SeverityError, "a null MX (RFC 7505) must be the only MX record")
+	case nullMX:
+		c.add("mx", SeverityInfo, "null MX published, the domain does not accept mail (RFC 7505)")
+	case len(c.report.MX) == 0:
+		c.add("mx", SeverityWarning, "no MX records, senders fall back to the domain's A/AAAA records; publish a null MX if the domain receives no mail")
+	default:
+		c.add("mx", SeverityOK, "mail is accepted by %s", strings.Join(c.report.MX, ", "))
+	}
+}
+
+func (c *mailChecker) checkSPF() {
+	domain := c.report.Domain
+	records, err := c.txt(domain)
+	if err != nil {
+		c.add("spf", SeverityError, "looking up SPF: %v", err)
+		return
+	}
+	spfRecords := filterSPF(records)
+	switch len(spfRecords) {
+	case 0:
+		c.add("spf", SeverityWarning, "no SPF record, receivers cannot tell which hosts may send mail for the domain")
+		return
+	case 1:
+	default:
+		c.add("spf", SeverityError, "%d SPF records published, receivers return permerror when there is more than one", len(spfRecords))
+		return
+	}
+	spf := &SPF{Record: spfRecords[0]}
+	c.report.SPF = spf
+	c.walkSPF(domain, spf.Record, spf, map[string]bool{dns.CanonicalName(domain): true}, true)
+
+	if spf.Lookups > maxSPFLookups {
+		c.add("spf", SeverityError, "evaluating SPF takes %d DNS lookups, more than the %d allowed (RFC 7208 4.6.4), receivers return permerror", spf.Lookups, maxSPFLookups)
+	} else {
+		c.add("spf", SeverityOK, "%d of %d DNS lookups used", spf.Lookups, maxSPFLookups)
+	}
+	if spf.VoidLookups > maxSPFVoidLookups {
+		c.add("spf", SeverityError, "%d lookups return no records, more than the %d allowed, receivers return permerror", spf.VoidLookups, maxSPFVoidLookups)
+	}
+	switch spf.All {
+	case "+all":
+		c.add("spf", SeverityError, "+all authorizes every host on the internet to send mail for the domain")
+	case "?all":
+		c.add("spf", SeverityWarning, "?all gives unlisted senders a neutral result, which offers no protection")
+	case "~all":
+		c.add("spf", SeverityInfo, "~all soft-fails unlisted senders; use -all once all senders are listed")
+	case "-all":
+		c.add("spf", SeverityOK, "-all rejects unlisted senders")
+	default:
+		c.add("spf", SeverityWarning, "no all mechanism, unlisted senders get a neutral result")
+	}
+}
+
+// walkSPF evaluates the terms of an SPF record, following include and
+// redirect targets and counting the DNS lookups they cost. seen holds the
+// records on the current include path to detect loops. top is set for
+// the queried domain and its redirect targets, whose all mechanism applies.
+func (c *mailChecker) walkSPF(domain, record string, spf *SPF, seen map[string]bool, top bool) {
+	terms := strings.Fields(record)[1:]
+	redirect := ""
+	hasAll := false
+	for _, term := range terms {
+		lower := strings.ToLower(term)
+		if name, value, ok := strings.Cut(lower, "="); ok && !strings.ContainsAny(name, ":/") {
+			if name == "redirect" {
+				redirect = value
+			}
+			continue
+		}
+		qualifier := "+"
+		if strings.ContainsAny(lower[:1], "+-~?") {
+			qualifier, lower = lower[:1], lower[1:]
+		}
+		mechanism, arg := lower, ""
+		if i := strings.IndexAny(lower, ":/"); i >= 0 {
+			mechanism, arg = lower[:i], lower[i:]
+		}
+		target := strings.TrimPrefix(arg, ":")
+		if i := strings.Index(target, "/"); i >= 0 {
+			target = target[:i]
+		}
+		switch mechanism {
+		case "all":
+			hasAll = true
+			if top {
+				spf.All = qualifier + "all"
+			}
+		case "include":
+			spf.Lookups++
+			if target == "" {
+				c.add("spf", SeverityError, "%s: include without a domain", domain)
+				continue
+			}
+			c.followSPF("include:"+target, target, spf, seen, false)
+		case "a", "mx", "exists":
+			spf.Lookups++
+			if target == "" {
+				if mechanism == "exists" {
+					c.add("spf", SeverityError, "%s: exists requires a domain", domain)
+					continue
+				}
+				target = domain
+			}
+			if strings.Contains(target, "%") {
+				continue
+			}
+			qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+			switch mechanism {
+			case "mx":
+				qtypes = []uint16{dns.TypeMX}
+			case "exists":
+				qtypes = []uint16{dns.TypeA}
+			}
+			if !c.exists(dns.Fqdn(target), qtypes...) {
+				spf.VoidLookups++
+			}
+		case "ptr":
+			spf.Lookups++
+			c.add("spf", SeverityWarning, "%s: the ptr mechanism is slow and unreliable and must not be used (RFC 7208 5.5)", domain)
+		case "ip4", "ip6":
+			if !validSPFNetwork(mechanism, target, arg) {
+				c.add("spf", SeverityError, "%s: invalid %s network %q", domain, mechanism, strings.TrimPrefix(arg, ":"))
+			}
+		default:
+			c.add("spf", SeverityError, "%s: unknown mechanism %q, receivers return permerror", domain, term)
+		}
+	}
+	if redirect != "" {
+		if hasAll {
+			c.add("spf", SeverityInfo, "%s: redirect=%s is ignored because the record has an all mechanism", domain, redirect)
+			return
+		}
+		spf.Lookups++
+		c.followSPF("redirect="+redirect, redirect, spf, seen, top)
+	}
+}
+
+func (c *mailChecker) followSPF(term, target string, spf *SPF, seen map[string]bool, top bool) {
+	if strings.Contains(target, "%") {
+		return
+	}
+	name := dns.CanonicalName(target)
+	if seen[name] {
+		c.add("spf", SeverityError, "%s loops back to a record that includes it", term)
+		return
+	}
+	seen[name] = true
+	records, err := c.txt(name)
+	if err != nil {
+		c.add("spf", SeverityError, "%s: %v", term, err)
+		return
+	}
+	spfRecords := filterSPF(records)
+	if len(spfRecords) == 0 {
+		spf.VoidLookups++
+		c.add("spf", SeverityError, "%s has no SPF record, receivers return permerror", term)
+		return
+	}
+	if len(spfRecords) > 1 {
+		c.add("spf", SeverityError, "%s has %d SPF records, receivers return permerror", term, len(spfRecords))
+		return
+	}
+	spf.Includes = append(spf.Includes, strings.TrimSuffix(name, "."))
+	c.walkSPF(name, spfRecords[0], spf, seen, top)
+	delete(seen, name)
+}
+
+func (c *mailChecker) checkDMARC() {
+	domain := c.report.Domain
+	name := "_dmarc." + domain
+	dmarc := c.dmarcRecord(name)
+	if dmarc == nil {
+		if org := c.orgDomain(domain); org != domain {
+			if orgDMARC := c.dmarcRecord("_dmarc." + org); orgDMARC != nil {
+				policy := orgDMARC.Tags["p"]
+				if sp, set := orgDMARC.Tags["sp"]; set {
+					policy = sp
+				}
+				c.add("dmarc", SeverityInfo, "no DMARC record at %s, the organizational domain's subdomain policy %s applies", name, policy)
+				c.report.DMARC = orgDMARC
+				return
+			}
+		}
+		c.add("dmarc", SeverityError, "no DMARC record at %s, receivers have no policy for mail failing SPF and DKIM", name)
+		return
+	}
+	c.report.DMARC = dmarc
+	tags := dmarc.Tags
+	ok := true
+	switch tags["p"] {
+	case "reject", "quarantine":
+	case "none":
+		ok = false
+		c.add("dmarc", SeverityWarning, "p=none only monitors, spoofed mail is still delivered")
+	case "":
+		c.add("dmarc", SeverityError, "the required p tag is missing, receivers ignore the record")
+		return
+	default:
+		c.add("dmarc", SeverityError, "invalid policy p=%s, use none, quarantine or reject", tags["p"])
+		return
+	}
+	if sp, set := tags["sp"]; set && sp != "none" && sp != "quarantine" && sp != "reject" {
+		ok = false
+		c.add("dmarc", SeverityError, "invalid subdomain policy sp=%s", sp)
+	}
+	if pct, set := tags["pct"]; set {
+		n, err := strconv.Atoi(pct)
+		switch {
+		case err != nil || n < 0 || n > 100:
+			ok = false
+			c.add("dmarc", SeverityError, "invalid pct=%s, use a number between 0 and 100", pct)
+		case n < 100:
+			ok = false
+			c.add("dmarc", SeverityInfo, "pct=%d applies the policy to only part of the failing mail", n)
+		}
+	}
+	for _, tag := range []string{"adkim", "aspf"} {
+		if v, set := tags[tag]; set && v != "r" && v != "s" {
+			ok = false
+			c.add("dmarc", SeverityError, "invalid %s=%s, use r (relaxed) or s (strict)", tag, v)
+		}
+	}
+	if _, set := tags["rua"]; !set {
+		ok = false
+		c.add("dmarc", SeverityInfo, "no rua tag, no aggregate reports will be sent to the domain owner")
+	}
+	for _, tag := range []string{"rua", "ruf"} {
+		for _, uri := range splitList(tags[tag]) {
+			if !c.checkReportURI(tag, uri) {
+				ok = false
+			}
+		}
+	}
+	if ok {
+		c.add("dmarc", SeverityOK, "policy p=%s", tags["p"])
+	}
+}
+
+// dmarcRecord fetches the DMARC record at name. Policy and alignment values
+// are keywords, which RFC 7489 matches case-insensitively, so they are
+// lowercased.
+func (c *mailChecker) dmarcRecord(name string) *TagRecord {
+	dmarc := c.tagRecord("dmarc", name, "v=DMARC1")
+	if dmarc == nil {
+		return nil
+	}
+	for _, tag := range []string{"p", "sp", "adkim", "aspf"} {
+		if v, set := dmarc.Tags[tag]; set {
+			dmarc.Tags[tag] = strings.ToLower(v)
+		}
+	}
+	return dmarc
+}
+
+// checkReportURI verifies a DMARC reporting address. Reports sent to another
+// domain need that domain's consent record (RFC 7489 section 7.1).
+func (c *mailChecker) checkReportURI(tag, uri string) bool {
+	address, found := strings.CutPrefix(strings.ToLower(uri), "mailto:")
+	if !found {
+		c.add("dmarc", SeverityError, "%s URI %q must be a mailto: address", tag, uri)
+		return false
+	}
+	if i := strings.Index(address, "!"); i >= 0 {
+		address = address[:i]
+	}
+	_, host, found := strings.Cut(address, "@")
+	if !found || host == "" {
+		c.add("dmarc", SeverityError, "%s address %q is not a valid email address", tag, address)
+		return false
+	}
+	host = dns.Fqdn(host)
+	if dns.IsSubDomain(c.orgDomain(c.report.Domain), host) {
+		return true
+	}
+	name := strings.TrimSuffix(c.report.Domain, ".") + "._report._dmarc." + host
+	records, err := c.txt(name)
+	if err == nil && len(records) > 0 && strings.HasPrefix(strings.ToLower(strings.ReplaceAll(records[0], " ", "")), "v=dmarc1") {
+		return true
+	}
+	c.add("dmarc", SeverityWarning, "%s sends reports to %s, which does not authorize it with a TXT record at %s", tag, host, name)
+	return false
+}
+
+func (c *mailChecker) orgDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return domain
+	}
+	return dns.Fqdn(org)
+}
+
+func (c *mailChecker) checkDKIM(selectors []string) {
+	for _, selector := range selectors {
+		name := selector + "._domainkey." + c.report.Domain
+		records, err := c.txt(name)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+		record := records[0]
+		tags := parseTags(record)
+		key := DKIMKey{Selector: selector, Name: name, Record: record, KeyType: "rsa", Testing: strings.Contains(tags["t"], "y")}
+		if k, set := tags["k"]; set {
+			key.KeyType = k
+		}
+		if v, set := tags["v"]; set && v != "DKIM1" {
+			c.add("dkim", SeverityError, "selector %s: version %q must be DKIM1", selector, v)
+		}
+		p, set := tags["p"]
+		switch {
+		case !set:
+			c.add("dkim", SeverityError, "selector %s: the required p tag is missing", selector)
+		case p == "":
+			key.Revoked = true
+			c.add("dkim", SeverityInfo, "selector %s: key is revoked (empty p tag)", selector)
+		default:
+			c.checkDKIMKey(&key, p)
+		}
+		if key.Testing {
+			c.add("dkim", SeverityInfo, "selector %s: testing mode (t=y), receivers treat signatures as unsigned", selector)
+		}
+		c.report.DKIM = append(c.report.DKIM, key)
+	}
+	if len(c.report.DKIM) == 0 {
+		c.add("dkim", SeverityWarning, "no DKIM key found for selectors %s; selectors cannot be discovered, pass the ones in use with --selector", strings.Join(selectors, ", "))
+	}
+}
+
+func (c *mailChecker) checkDKIMKey(key *DKIMKey, p string) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(p), ""))
+	if err != nil {
+		c.add("dkim", SeverityError, "selector %s: public key is not valid base64", key.Selector)
+		return
+	}
+	switch key.KeyType {
+	case "ed25519":
+		if len(der) != 32 {
+			c.add("dkim", SeverityError, "selector %s: ed25519 key is %d bytes, expected 32", key.Selector, len(der))
+			return
+		}
+		key.Bits = 256
+	case "rsa":
+		var pub *rsa.PublicKey
+		if parsed, err := x509.ParsePKIXPublicKey(der); err == nil {
+			pub, _ = parsed.(*rsa.PublicKey)
+		} else if parsed, err := x509.ParsePKCS1PublicKey(der); err == nil {
+			pub = parsed
+		}
+		if pub == nil {
+			c.add("dkim", SeverityError, "selector %s: public key is not a valid RSA key", key.Selector)
+			return
+		}
+		key.Bits = pub.N.BitLen()
+		switch {
+		case key.Bits < 1024:
+			c.add("dkim", SeverityError, "selector %s: %d-bit RSA key is too short, receivers ignore it (RFC 8301)", key.Selector, key.Bits)
+			return
+		case key.Bits < 2048:
+			c.add("dkim", SeverityWarning, "selector %s: %d-bit RSA key, 2048 bits are recommended", key.Selector, key.Bits)
+			return
+		}
+	default:
+		c.add("dkim", SeverityError, "selector %s: unknown key type k=%s", key.Selector, key.KeyType)
+		return
+	}
+	c.add("dkim", SeverityOK, "selector %s: %s %d-bit key", key.Selector, key.KeyType, key.Bits)
+}
+
+func (c *mailChecker) checkMTASTS() {
+	domain := c.report.Domain
+	sts := c.tagRecord("mta_sts", "_mta-sts."+domain, "v=STSv1")
+	if sts == nil {
+		c.add("mta_sts", SeverityInfo, "no MTA-STS record, senders cannot require TLS when delivering to the domain")
+		return
+	}
+	c.report.MTASTS = sts
+	ok := true
+	if id := sts.Tags["id"]; !mtaSTSID.MatchString(id) {
+		ok = false
+		c.add("mta_sts", SeverityError, "id=%q must be 1-32 letters or digits, senders ignore the record", id)
+	}
+	host := "mta-sts." + domain
+	if !c.exists(host, dns.TypeA, dns.TypeAAAA) {
+		ok = false
+		c.add("mta_sts", SeverityError, "%s does not resolve, senders cannot fetch the policy from https://%s/.well-known/mta-sts.txt", host, strings.TrimSuffix(host, "."))
+	}
+	if ok {
+		c.add("mta_sts", SeverityOK, "policy id %s", sts.Tags["id"])
+	}
+}
+
+func (c *mailChecker) checkTLSRPT() {
+	rpt := c.tagRecord("tls_rpt", "_smtp._tls."+c.report.Domain, "v=TLSRPTv1")
+	if rpt == nil {
+		severity := SeverityInfo
+		if c.report.MTASTS != nil {
+			severity = SeverityWarning
+		}
+		c.add("tls_rpt", severity, "no TLS-RPT record, TLS delivery failures will not be reported")
+		return
+	}
+	c.report.TLSRPT = rpt
+	rua := splitList(rpt.Tags["rua"])
+	if len(rua) == 0 {
+		c.add("tls_rpt", SeverityError, "the required rua tag is missing")
+		return
+	}
+	for _, uri := range rua {
+		lower := strings.ToLower(uri)
+		if !strings.HasPrefix(lower, "mailto:") && !strings.HasPrefix(lower, "https://") {
+			c.add("tls_rpt", SeverityError, "rua URI %q must use mailto: or https:", uri)
+			return
+		}
+	}
+	c.add("tls_rpt", SeverityOK, "reports go to %s", strings.Join(rua, ", "))
+}
+
+func (c *mailChecker) checkBIMI() {
+	bimi := c.tagRecord("bimi", "default._bimi."+c.report.Domain, "v=BIMI1")
+	if bimi == nil {
+		c.add("bimi", SeverityInfo, "no BIMI record, mail clients will not show a brand logo")
+		return
+	}
+	c.report.BIMI = bimi
+	ok := true
+	logo := bimi.Tags["l"]
+	switch {
+	case logo == "":
+		ok = false
+		c.add("bimi", SeverityWarning, "no logo URL (l tag), the record declines BIMI participation")
+	case !strings.HasPrefix(strings.ToLower(logo), "https://"):
+		ok = false
+		c.add("bimi", SeverityError, "logo URL %s must use https", logo)
+	case !strings.HasSuffix(strings.ToLower(logo), ".svg"):
+		ok = false
+		c.add("bimi", SeverityWarning, "logo URL %s should point to an SVG Tiny PS file", logo)
+	}
+	if bimi.Tags["a"] == "" {
+		ok = false
+		c.add("bimi", SeverityInfo, "no mark certificate (a tag), most mailbox providers only show logos backed by a VMC")
+	}
+	if dmarc := c.report.DMARC; dmarc == nil || (dmarc.Tags["p"] != "quarantine" && dmarc.Tags["p"] != "reject") || (dmarc.Tags["pct"] != "" && dmarc.Tags["pct"] != "100") {
+		ok = false
+		c.add("bimi", SeverityWarning, "BIMI requires an enforced DMARC policy (p=quarantine or p=reject at pct=100)")
+	}
+	if ok {
+		c.add("bimi", SeverityOK, "logo %s", logo)
+	}
+}
+
+func printMailReport(report *MailReport) {
+	fmt.Printf("\n📧 Mail authentication for %s\n", report.Domain)
+	if len(report.MX) > 0 {
+		fmt.Printf("  MX:      %s\n", strings.Join(report.MX, ", "))
+	}
+	if report.SPF != nil {
+		fmt.Printf("  SPF:     %s (%d/%d lookups)\n", report.SPF.Record, report.SPF.Lookups, maxSPFLookups)
+	}
+	for _, record := range []struct {
+		label string
+		tags  *TagRecord
+	}{{"DMARC:  ", report.DMARC}, {"MTA-STS:", report.MTASTS}, {"TLS-RPT:", report.TLSRPT}, {"BIMI:   ", report.BIMI}} {
+		if record.tags != nil {
+			fmt.Printf("  %s %s\n", record.label, record.tags.Record)
+		}
+	}
+	for _, key := range report.DKIM {
+		fmt.Printf("  DKIM:    %s (%s", key.Selector, key.KeyType)
+		if key.Bits > 0 {
+			fmt.Printf(" %d bits", key.Bits)
+		}
+		fmt.Println(")")
+	}
+	fmt.Println()
+	printFindings(report.Findings)
+}
+
+// parseTags splits a "tag=value; tag=value" record. Tag names are
+// lowercased, values are kept as published.
+func parseTags(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return tags
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+func filterSPF(records []string) []string {
+	var spf []string
+	for _, record := range records {
+		lower := strings.ToLower(record)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			spf = append(spf, record)
+		}
+	}
+	return spf
+}
+
+func validSPFNetwork(mechanism, target, arg string) bool {
+	network := strings.TrimPrefix(arg, ":")
+	if target == "" {
+		return false
+	}
+	if strings.Contains(network, "/") {
+		prefix, err := netip.ParsePrefix(network)
+		return err == nil && prefix.Addr().Is4() == (mechanism == "ip4")
+	}
+	addr, err := netip.ParseAddr(network)
+	return err == nil && addr.Is4() == (mechanism == "ip4")
+}
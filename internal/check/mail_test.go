@@ -0,0 +1,50 @@
+package check
+
+import (
+	"strings"
+	"testing"
+)
+
+// findings returns the messages of the findings of check at severity.
+func findings(report *MailReport, check string, severity Severity) []string {
+	var messages []string
+	for _, f := range report.Findings {
+		if f.Check == check && f.Severity == severity {
+			messages = append(messages, f.Message)
+		}
+	}
+	return messages
+}
+
+func TestMailDMARCPolicyCase(t *testing.T) {
+	tests := []struct {
+		record   string
+		severity Severity
+		enforced bool
+	}{
+		{"v=DMARC1; p=Reject; sp=Quarantine; adkim=S; rua=mailto:dmarc@example.test", SeverityOK, true},
+		{"v=DMARC1; p=QUARANTINE; rua=mailto:dmarc@example.test", SeverityOK, true},
+		{"v=DMARC1; p=None; rua=mailto:dmarc@example.test", SeverityWarning, false},
+		{"v=DMARC1; p=block; rua=mailto:dmarc@example.test", SeverityError, false},
+	}
+	for _, tt := range tests {
+		addr := startServer(t, stubResolver{txt: map[string]string{
+			"_dmarc.example.test.":        tt.record,
+			"default._bimi.example.test.": "v=BIMI1; l=https://example.test/logo.svg; a=https://example.test/vmc.pem",
+		}})
+		report := Mail("example.test.", nil, addr, testConfig)
+		if got := findings(report, "dmarc", tt.severity); len(got) == 0 {
+			t.Errorf("%q: no %s dmarc finding in %+v", tt.record, tt.severity, report.Findings)
+		}
+		if tt.severity != SeverityError && len(findings(report, "dmarc", SeverityError)) > 0 {
+			t.Errorf("%q: unexpected dmarc errors %v", tt.record, findings(report, "dmarc", SeverityError))
+		}
+		var unenforced bool
+		for _, message := range findings(report, "bimi", SeverityWarning) {
+			unenforced = unenforced || strings.Contains(message, "enforced DMARC")
+		}
+		if unenforced == tt.enforced {
+			t.Errorf("%q: BIMI reports the policy as enforced = %v, want %v", tt.record, !unenforced, tt.enforced)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// Denial of existence mechanisms found in a zone.
+const (
+	DenialNSEC     = "nsec"
+	DenialNSEC3    = "nsec3"
+	DenialMinimal  = "minimal"
+	DenialUnsigned = "unsigned"
+	DenialUnknown  = "unknown"
+)
+
+// maxHashAttempts bounds the local hashing done to find a name whose NSEC3
+// hash falls into a gap not yet covered.
+const maxHashAttempts = 1 << 20
+
+// NSECReport lists what the denial of existence records of a zone reveal.
+type NSECReport struct {
+	Zone       string         `json:"zone"`
+	Nameserver string         `json:"nameserver"`
+	Denial     string         `json:"denial"`
+	Names      []NSECName     `json:"names,omitempty"`
+	NSEC3      *NSEC3Chain    `json:"nsec3,omitempty"`
+	Queries    int            `json:"queries"`
+	Complete   bool           `json:"complete"`
+	Findings   []Finding      `json:"findings"`
+	Summary    map[string]int `json:"summary"`
+}
+
+type NSECName struct {
+	Name  string   `json:"name"`
+	Types []string `json:"types"`
+}
+
+// NSEC3Chain holds the hashed owner names collected from an NSEC3 zone
+// together with the parameters needed to attack them offline.
+type NSEC3Chain struct {
+	Algorithm  uint8       `json:"algorithm"`
+	Iterations uint16      `json:"iterations"`
+	Salt       string      `json:"salt"`
+	OptOut     bool        `json:"opt_out"`
+	Hashes     []NSEC3Hash `json:"hashes"`
+}
+
+type NSEC3Hash struct {
+	Hash  string   `json:"hash"`
+	Next  string   `json:"next"`
+	Types []string `json:"types"`
+}
+
+type nsecWalker struct {
+	querier
+	nameserver string
+	maxQueries int
+	report     *NSECReport
+}
+
+func RunNSECWalk(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	zone, err := ldns.ToASCII(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid zone", zap.Error(err))
+	}
+	zone = dns.Fqdn(zone)
+	if err := ldns.ValidateDomain(zone, ldns.ModeDNSName); err != nil {
+		logger.GetLogger().Fatal("Invalid zone", zap.Error(err))
+	}
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	var nameserver string
+	if len(args) > 1 {
+		if prepared := ldns.PrepareNameservers(args[1:2], cfg.IPVersion); len(prepared) > 0 {
+			nameserver = prepared[0]
+		}
+	} else {
+		resolver, err := resolverFromFlags(cmd, cfg)
+		if err != nil {
+			logger.GetLogger().Fatal("No resolver available", zap.Error(err))
+		}
+		nameserver, err = authoritative(zone, resolver, cfg)
+		if err != nil {
+			logger.GetLogger().Fatal("No authoritative nameserver found", zap.Error(err))
+		}
+	}
+	if nameserver == "" {
+		logger.GetLogger().Fatal("No valid nameserver provided")
+	}
+	maxQueries, _ := cmd.Flags().GetInt("max-queries")
+
+	logger.GetLogger().Info("Walking zone", zap.String("zone", zone), zap.String("nameserver", nameserver))
+	report := NSECWalk(zone, nameserver, maxQueries, cfg)
+	if hashFile, _ := cmd.Flags().GetString("hashes"); hashFile != "" && report.NSEC3 != nil {
+		if err := writeHashes(hashFile, zone, report.NSEC3); err != nil {
+			logger.GetLogger().Fatal("Failed to write hashes", zap.Error(err))
+		}
+	}
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printNSECReport(report)
+	}
+}
+
+// authoritative returns the address of the first nameserver of zone that the
+// resolver can find.
+func authoritative(zone, resolver string, cfg config.Config) (string, error) {
+	addrs, err := authoritativeServers(zone, resolver, cfg)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+// authoritativeServers returns the addresses of every nameserver of zone
+// that the resolver can find.
+func authoritativeServers(zone, resolver string, cfg config.Config) ([]string, error) {
+	q := querier{cfg: cfg}
+	r, err := q.ask(resolver, zone, dns.TypeNS, true)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, name := range nsNames(r.Answer, zone) {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if (qtype == dns.TypeA && cfg.IPVersion == 6) || (qtype == dns.TypeAAAA && cfg.IPVersion == 4) {
+				continue
+			}
+			if r, err := q.ask(resolver, name, qtype, true); err == nil {
+				for _, addr := range addresses(r.Answer, name) {
+					servers = append(servers, net.JoinHostPort(addr, "53"))
+				}
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s has no reachable nameservers", zone)
+	}
+	return servers, nil
+}
+
+// NSECWalk enumerates zone through the denial of existence records served by
+// nameserver, sending at most maxQueries queries.
+func NSECWalk(zone, nameserver string, maxQueries int, cfg config.Config) *NSECReport {
+	w := &nsecWalker{
+		querier:    querier{cfg: cfg},
+		nameserver: nameserver,
+		maxQueries: maxQueries,
+		report:     &NSECReport{Zone: zone, Nameserver: nameserver},
+	}
+	w.detect()
+	switch w.report.Denial {
+	case DenialNSEC:
+		w.walkNSEC()
+	case DenialNSEC3:
+		w.walkNSEC3()
+	}
+	w.assess()
+	Rank(w.report.Findings)
+	w.report.Summary = Summarize(w.report.Findings)
+	return w.report
+}
+
+func (w *nsecWalker) add(check string, severity Severity, format string, args ...interface{}) {
+	w.report.Findings = append(w.report.Findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// query sends a DNSSEC OK query for name.
+func (w *nsecWalker) query(name string, qtype uint16) (*dns.Msg, error) {
+	w.report.Queries++
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(4096, true)
+	return w.exchange(w.nameserver, m)
+}
+
+// detect probes a name that cannot exist and looks at the records proving
+// its absence.
+func (w *nsecWalker) detect() {
+	zone := w.report.Zone
+	r, err := w.query(ldns.RandomLabel()+"."+zone, dns.TypeA)
+	if err != nil {
+		w.report.Denial = DenialUnknown
+		w.add("nsec", SeverityError, "probe query failed: %v", err)
+		return
+	}
+	for _, rr := range r.Ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			w.report.Denial = DenialNSEC
+			if isMinimalNSEC(rr) {
+				w.report.Denial = DenialMinimal
+			}
+			return
+		case *dns.NSEC3:
+			w.report.Denial = DenialNSEC3
+			return
+		}
+	}
+	if r, err := w.query(zone, dns.TypeDNSKEY); err == nil && len(r.Answer) > 0 {
+		w.report.Denial = DenialUnknown
+		w.add("nsec", SeverityWarning, "the zone has DNSKEY records but the server returned no NSEC or NSEC3 proof")
+		return
+	}
+	w.report.Denial = DenialUnsigned
+}
+
+// walkNSEC follows the NSEC chain from the apex until it returns to it.
+func (w *nsecWalker) walkNSEC() {
+	zone := w.report.Zone
+	seen := make(map[string]bool)
+	current := zone
+	for w.report.Queries < w.maxQueries {
+		nsec := w.nsecAt(current)
+		if nsec == nil {
+			w.add("nsec", SeverityWarning, "no NSEC record returned for %s, walk stopped", current)
+			return
+		}
+		if isMinimalNSEC(nsec) {
+			w.report.Denial = DenialMinimal
+			return
+		}
+		seen[current] = true
+		w.report.Names = append(w.report.Names, NSECName{Name: current, Types: ldns.TypeNames(nsec.TypeBitMap)})
+		next := dns.CanonicalName(nsec.NextDomain)
+		if next == zone {
+			w.report.Complete = true
+			return
+		}
+		if seen[next] || !dns.IsSubDomain(zone, next) {
+			w.add("nsec", SeverityWarning, "NSEC chain points to %s, walk stopped", next)
+			return
+		}
+		current = next
+	}
+}
+
+// nsecAt returns the NSEC record owned by name, asking for it directly and
+// falling back to the proof for the name's immediate successor.
+func (w *nsecWalker) nsecAt(name string) *dns.NSEC {
+	if r, err := w.query(name, dns.TypeNSEC); err == nil {
+		for _, rr := range r.Answer {
+			if nsec, ok := rr.(*dns.NSEC); ok && strings.EqualFold(nsec.Hdr.Name, name) {
+				return nsec
+			}
+		}
+	}
+	if w.report.Queries >= w.maxQueries {
+		return nil
+	}
+	r, err := w.query(`\000.`+name, dns.TypeA)
+	if err != nil {
+		return nil
+	}
+	for _, rr := range r.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok && strings.EqualFold(nsec.Hdr.Name, name) {
+			return nsec
+		}
+	}
+	return nil
+}
+
+// walkNSEC3 queries names whose hashes fall into gaps of the collected NSEC3
+// records until the hash ring is closed.
+func (w *nsecWalker) walkNSEC3() {
+	zone := w.report.Zone
+	chain := &NSEC3Chain{}
+	w.report.NSEC3 = chain
+	hashes := make(map[string]*NSEC3Hash)
+	counter := 0
+	for w.report.Queries < w.maxQueries {
+		name := ""
+		for attempts := 0; attempts < maxHashAttempts; attempts++ {
+			counter++
+			candidate := fmt.Sprintf("cdns-%d.%s", counter, zone)
+			if len(hashes) == 0 || !covered(hashes, dns.HashName(candidate, chain.Algorithm, chain.Iterations, chain.Salt)) {
+				name = candidate
+				break
+			}
+		}
+		if name == "" {
+			break
+		}
+		r, err := w.query(name, dns.TypeA)
+		if err != nil {
+			w.add("nsec3", SeverityWarning, "query for %s failed: %v", name, err)
+			return
+		}
+		for _, rr := range r.Ns {
+			nsec3, ok := rr.(*dns.NSEC3)
+			if !ok {
+				continue
+			}
+			if len(hashes) == 0 {
+				chain.Algorithm = nsec3.Hash
+				chain.Iterations = nsec3.Iterations
+				chain.Salt = nsec3.Salt
+			}
+			chain.OptOut = chain.OptOut || nsec3.Flags&1 == 1
+			hash := strings.ToUpper(strings.SplitN(nsec3.Hdr.Name, ".", 2)[0])
+			hashes[hash] = &NSEC3Hash{Hash: hash, Next: strings.ToUpper(nsec3.NextDomain), Types: ldns.TypeNames(nsec3.TypeBitMap)}
+		}
+		if len(hashes) == 0 {
+			w.add("nsec3", SeverityWarning, "NXDOMAIN answer for %s carried no NSEC3 records", name)
+			return
+		}
+		if ringClosed(hashes) {
+			w.report.Complete = true
+			break
+		}
+	}
+	for _, h := range hashes {
+		chain.Hashes = append(chain.Hashes, *h)
+	}
+	sort.Slice(chain.Hashes, func(i, j int) bool { return chain.Hashes[i].Hash < chain.Hashes[j].Hash })
+}
+
+// assess reports how enumerable the zone is.
+func (w *nsecWalker) assess() {
+	report := w.report
+	extent := "partially"
+	if report.Complete {
+		extent = "fully"
+	}
+	switch report.Denial {
+	case DenialNSEC:
+		w.add("enumeration", SeverityWarning, "zone is %s enumerable through NSEC: %d names listed in %d queries", extent, len(report.Names), report.Queries)
+	case DenialMinimal:
+		w.add("enumeration", SeverityOK, "NSEC records are minimally covering (online signing), names cannot be walked")
+	case DenialNSEC3:
+		chain := report.NSEC3
+		w.add("enumeration", SeverityWarning, "zone is %s enumerable through NSEC3: %d hashes collected in %d queries, names can be recovered with an offline dictionary attack",
+			extent, len(chain.Hashes), report.Queries)
+		if chain.Iterations > 0 {
+			w.add("nsec3", SeverityWarning, "%d extra hash iterations cost resolvers CPU without slowing attackers meaningfully, RFC 9276 recommends 0", chain.Iterations)
+		}
+		if chain.Salt != "" && chain.Salt != "-" {
+			w.add("nsec3", SeverityInfo, "salt %s adds no protection, RFC 9276 recommends an empty salt", chain.Salt)
+		}
+		if chain.OptOut {
+			w.add("nsec3", SeverityInfo, "opt-out is set, insecure delegations are not listed in the chain")
+		}
+	case DenialUnsigned:
+		w.add("enumeration", SeverityInfo, "zone is not DNSSEC signed, there is no chain to walk")
+	}
+	if !report.Complete && (report.Denial == DenialNSEC || report.Denial == DenialNSEC3) && report.Queries >= w.maxQueries {
+		w.add("enumeration", SeverityInfo, "stopped after %d queries, raise --max-queries to continue", w.maxQueries)
+	}
+}
+
+// isMinimalNSEC recognizes NSEC records generated on the fly to cover only
+// the queried name, whose next name is the owner's immediate successor.
+func isMinimalNSEC(nsec *dns.NSEC) bool {
+	return strings.HasPrefix(nsec.NextDomain, `\000.`)
+}
+
+// covered reports whether hash lies within an interval already proven by
+// one of the collected NSEC3 records, or is itself an owner hash.
+func covered(hashes map[string]*NSEC3Hash, hash string) bool {
+	for owner, h := range hashes {
+		switch {
+		case hash == owner:
+			return true
+		case owner < h.Next:
+			if hash > owner && hash < h.Next {
+				return true
+			}
+		default:
+			// The last record of the ring wraps around to the first hash.
+			if hash > owner || hash < h.Next {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ringClosed(hashes map[string]*NSEC3Hash) bool {
+	for _, h := range hashes {
+		if _, ok := hashes[h.Next]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// writeHashes stores the collected NSEC3 hashes in the format read by
+// hashcat mode 8300: hash:.zone:salt:iterations.
+func writeHashes(path, zone string, chain *NSEC3Chain) error {
+	salt := chain.Salt
+	if salt == "-" {
+		salt = ""
+	}
+	var b strings.Builder
+	for _, h := range chain.Hashes {
+		fmt.Fprintf(&b, "%s:.%s:%s:%d\n", strings.ToLower(h.Hash), strings.TrimSuffix(zone, "."), strings.ToLower(salt), chain.Iterations)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func printNSECReport(report *NSECReport) {
+	fmt.Printf("\n🚶 Zone walk of %s via %s (%s, %d queries)\n", report.Zone, report.Nameserver, report.Denial, report.Queries)
+	for _, name := range report.Names {
+		fmt.Printf("  %s\t%s\n", ldns.ToUnicode(name.Name), strings.Join(name.Types, " "))
+	}
+	if chain := report.NSEC3; chain != nil {
+		fmt.Printf("  NSEC3 algorithm %d, %d iterations, salt %q, opt-out %t\n", chain.Algorithm, chain.Iterations, chain.Salt, chain.OptOut)
+		for _, h := range chain.Hashes {
+			fmt.Printf("  %s -> %s\t%s\n", h.Hash, h.Next, strings.Join(h.Types, " "))
+		}
+	}
+	fmt.Println()
+	printFindings(report.Findings)
+}
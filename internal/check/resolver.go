@@ -0,0 +1,383 @@
+package check
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/netip"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// Resolver audit checks, also the keys of --probe.
+const (
+	AuditRecursion     = "recursion"
+	AuditQNAMEMin      = "qname-min"
+	Audit0x20          = "0x20"
+	AuditRebinding     = "rebinding"
+	AuditDNSSEC        = "dnssec"
+	AuditECS           = "ecs"
+	AuditAmplification = "amplification"
+)
+
+// DefaultResolverProbes are the public names queried by each check. Their
+// answers are known: qnamemintest.internet.nl tells whether the query
+// reached it minimised, nip.io maps names to the address they contain,
+// dnssec-failed.org is deliberately mis-signed and o-o.myaddr.l.google.com
+// echoes the client subnet it received.
+var DefaultResolverProbes = map[string]string{
+	AuditRecursion:     "example.com.",
+	AuditQNAMEMin:      "qnamemintest.internet.nl.",
+	Audit0x20:          "example.com.",
+	AuditRebinding:     "192.168.0.1.nip.io.",
+	AuditDNSSEC:        "dnssec-failed.org.",
+	AuditECS:           "o-o.myaddr.l.google.com.",
+	AuditAmplification: "isc.org.",
+}
+
+// amplificationBufSize is the EDNS UDP payload size advertised by the
+// amplification probes, as an attacker would.
+const amplificationBufSize = 4096
+
+// maxAmplification is the response to query size ratio above which a
+// resolver is a useful reflector.
+const maxAmplification = 10
+
+// ResolverReport is the report card of a recursive resolver.
+type ResolverReport struct {
+	Resolver      string            `json:"resolver"`
+	Probes        map[string]string `json:"probes"`
+	Amplification []Amplification   `json:"amplification,omitempty"`
+	Findings      []Finding         `json:"findings"`
+	Summary       map[string]int    `json:"summary"`
+}
+
+// Amplification compares the size of a query with the UDP response it got.
+type Amplification struct {
+	Type         string  `json:"type"`
+	QuerySize    int     `json:"query_size"`
+	ResponseSize int     `json:"response_size"`
+	Factor       float64 `json:"factor"`
+	Truncated    bool    `json:"truncated,omitempty"`
+}
+
+type resolverAuditor struct {
+	querier
+	resolver string
+	report   *ResolverReport
+}
+
+func RunAuditResolver(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	prepared := ldns.PrepareNameservers(args[:1], cfg.IPVersion)
+	if len(prepared) == 0 {
+		logger.GetLogger().Fatal("Invalid resolver", zap.String("resolver", args[0]))
+	}
+	specs, _ := cmd.Flags().GetStringSlice("probe")
+	probes, err := ResolverProbes(specs)
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid probe", zap.Error(err))
+	}
+
+	logger.GetLogger().Info("Auditing resolver", zap.String("resolver", prepared[0]))
+	report := AuditResolver(prepared[0], probes, cfg)
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printResolverReport(report)
+	}
+	if Failed(report.Findings) {
+		os.Exit(1)
+	}
+}
+
+// ResolverProbes returns the default probe names overridden by "check=name"
+// specifications.
+func ResolverProbes(specs []string) (map[string]string, error) {
+	probes := make(map[string]string, len(DefaultResolverProbes))
+	for check, name := range DefaultResolverProbes {
+		probes[check] = name
+	}
+	for _, spec := range specs {
+		check, name, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("probe must be given as check=name, got %q", spec)
+		}
+		if _, known := DefaultResolverProbes[check]; !known {
+			return nil, fmt.Errorf("unknown check %q", check)
+		}
+		name, err := ldns.ToASCII(name)
+		if err != nil {
+			return nil, err
+		}
+		name = dns.Fqdn(name)
+		if err := ldns.ValidateDomain(name, ldns.ModeDNSName); err != nil {
+			return nil, err
+		}
+		probes[check] = name
+	}
+	return probes, nil
+}
+
+// AuditResolver checks how resolver treats recursive queries from this
+// client. The findings keep the order of the checks so the report reads as a
+// report card.
+func AuditResolver(resolver string, probes map[string]string, cfg config.Config) *ResolverReport {
+	a := &resolverAuditor{
+		querier:  querier{cfg: cfg},
+		resolver: resolver,
+		report:   &ResolverReport{Resolver: resolver, Probes: probes},
+	}
+	if a.checkRecursion() {
+		a.checkQNAMEMin()
+		a.check0x20()
+		a.checkRebinding()
+		a.checkDNSSEC()
+		a.checkECS()
+		a.checkAmplification()
+	}
+	a.report.Summary = Summarize(a.report.Findings)
+	return a.report
+}
+
+func (a *resolverAuditor) add(check string, severity Severity, format string, args ...interface{}) {
+	a.report.Findings = append(a.report.Findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkRecursion reports whether the resolver recurses for this client; the
+// other checks are only meaningful when it does.
+func (a *resolverAuditor) checkRecursion() bool {
+	probe := a.report.Probes[AuditRecursion]
+	r, err := a.ask(a.resolver, probe, dns.TypeA, true)
+	switch {
+	case err != nil:
+		a.add(AuditRecursion, SeverityError, "no response: %v", err)
+		return false
+	case r.Rcode == dns.RcodeRefused:
+		a.add(AuditRecursion, SeverityOK, "refuses recursive queries from this client")
+		return false
+	case !r.RecursionAvailable:
+		a.add(AuditRecursion, SeverityInfo, "recursion is not available (%s), the server is not a resolver for this client", dns.RcodeToString[r.Rcode])
+		return false
+	case r.Rcode != dns.RcodeSuccess:
+		a.add(AuditRecursion, SeverityWarning, "recursion available but %s answered %s", probe, dns.RcodeToString[r.Rcode])
+	default:
+		a.add(AuditRecursion, SeverityWarning, "answers recursive queries from this client, if it is reachable from the internet it is an open resolver")
+	}
+	return true
+}
+
+// checkQNAMEMin asks for a TXT record whose content depends on whether the
+// authoritative server saw minimised queries (RFC 9156).
+func (a *resolverAuditor) checkQNAMEMin() {
+	r, err := a.ask(a.resolver, a.report.Probes[AuditQNAMEMin], dns.TypeTXT, true)
+	if err != nil {
+		a.add(AuditQNAMEMin, SeverityInfo, "could not be determined: %v", err)
+		return
+	}
+	txt := strings.Join(txtStrings(r.Answer), " ")
+	switch {
+	case strings.HasPrefix(txt, "HOORAY"):
+		a.add(AuditQNAMEMin, SeverityOK, "QNAME minimisation is enabled")
+	case strings.HasPrefix(txt, "NO"):
+		a.add(AuditQNAMEMin, SeverityWarning, "QNAME minimisation is not enabled, full query names are sent to every authoritative server")
+	default:
+		a.add(AuditQNAMEMin, SeverityInfo, "could not be determined, %s returned %s", a.report.Probes[AuditQNAMEMin], dns.RcodeToString[r.Rcode])
+	}
+}
+
+// check0x20 sends the probe name in random case; resolvers using 0x20
+// encoding must preserve it to match responses.
+func (a *resolverAuditor) check0x20() {
+	name := mixCase(a.report.Probes[Audit0x20])
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	r, err := a.exchange(a.resolver, m)
+	switch {
+	case err != nil:
+		a.add(Audit0x20, SeverityInfo, "could not be determined: %v", err)
+	case len(r.Question) == 0:
+		a.add(Audit0x20, SeverityWarning, "response carries no question section")
+	case r.Question[0].Name != name:
+		a.add(Audit0x20, SeverityWarning, "query case %s was answered as %s, 0x20 mixed case is not preserved", name, r.Question[0].Name)
+	default:
+		a.add(Audit0x20, SeverityOK, "0x20 mixed case is preserved (%s)", name)
+	}
+}
+
+// checkRebinding resolves a public name pointing at a private address, which
+// a resolver protecting against DNS rebinding drops.
+func (a *resolverAuditor) checkRebinding() {
+	probe := a.report.Probes[AuditRebinding]
+	r, err := a.ask(a.resolver, probe, dns.TypeA, true)
+	if err != nil {
+		a.add(AuditRebinding, SeverityInfo, "could not be determined: %v", err)
+		return
+	}
+	var private []string
+	for _, rr := range r.Answer {
+		var addr string
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr = rr.A.String()
+		case *dns.AAAA:
+			addr = rr.AAAA.String()
+		}
+		if ip, err := netip.ParseAddr(addr); err == nil && isInternal(ip) {
+			private = append(private, addr)
+		}
+	}
+	switch {
+	case len(private) > 0:
+		a.add(AuditRebinding, SeverityWarning, "%s resolved to %s, private answers are not filtered (no DNS rebinding protection)", probe, strings.Join(private, ", "))
+	case r.Rcode != dns.RcodeSuccess:
+		// The probe failed to resolve, which says nothing about filtering.
+		a.add(AuditRebinding, SeverityInfo, "could not be determined, %s returned %s", probe, dns.RcodeToString[r.Rcode])
+	default:
+		a.add(AuditRebinding, SeverityOK, "private answers for %s are filtered", probe)
+	}
+}
+
+// checkDNSSEC resolves a name with a broken signature chain, which a
+// validating resolver answers with SERVFAIL.
+func (a *resolverAuditor) checkDNSSEC() {
+	probe := a.report.Probes[AuditDNSSEC]
+	m := new(dns.Msg)
+	m.SetQuestion(probe, dns.TypeA)
+	m.SetEdns0(1232, true)
+	r, err := a.exchange(a.resolver, m)
+	switch {
+	case err != nil:
+		a.add(AuditDNSSEC, SeverityInfo, "could not be determined: %v", err)
+	case r.Rcode == dns.RcodeServerFailure:
+		a.add(AuditDNSSEC, SeverityOK, "validates DNSSEC, the bogus name %s was rejected", probe)
+	case len(r.Answer) > 0:
+		a.add(AuditDNSSEC, SeverityWarning, "answered the bogus name %s, DNSSEC is not validated", probe)
+	default:
+		a.add(AuditDNSSEC, SeverityInfo, "could not be determined, %s returned %s", probe, dns.RcodeToString[r.Rcode])
+	}
+}
+
+// checkECS asks a name whose authoritative server echoes the EDNS Client
+// Subnet it received from the resolver (RFC 7871).
+func (a *resolverAuditor) checkECS() {
+	r, err := a.ask(a.resolver, a.report.Probes[AuditECS], dns.TypeTXT, true)
+	if err != nil {
+		a.add(AuditECS, SeverityInfo, "could not be determined: %v", err)
+		return
+	}
+	for _, txt := range txtStrings(r.Answer) {
+		if subnet, ok := strings.CutPrefix(txt, "edns0-client-subnet "); ok {
+			a.add(AuditECS, SeverityInfo, "forwards the client subnet %s to authoritative servers", subnet)
+			return
+		}
+	}
+	a.add(AuditECS, SeverityOK, "does not forward the client subnet")
+}
+
+// checkAmplification measures how much larger ANY and DNSKEY responses are
+// than the queries triggering them.
+func (a *resolverAuditor) checkAmplification() {
+	probe := a.report.Probes[AuditAmplification]
+	worst := 0.0
+	for _, qtype := range []uint16{dns.TypeANY, dns.TypeDNSKEY} {
+		m := new(dns.Msg)
+		m.SetQuestion(probe, qtype)
+		m.SetEdns0(amplificationBufSize, true)
+		r, err := a.exchange(a.resolver, m)
+		if err != nil {
+			continue
+		}
+		// Exchange retries truncated responses over TCP, a reflected
+		// UDP response cannot exceed the advertised buffer size.
+		amp := Amplification{Type: dns.TypeToString[qtype], QuerySize: m.Len(), ResponseSize: r.Len()}
+		if amp.ResponseSize > amplificationBufSize {
+			amp.ResponseSize = amplificationBufSize
+			amp.Truncated = true
+		}
+		amp.Factor = math.Round(float64(amp.ResponseSize)/float64(amp.QuerySize)*10) / 10
+		worst = max(worst, amp.Factor)
+		a.report.Amplification = append(a.report.Amplification, amp)
+	}
+	switch {
+	case len(a.report.Amplification) == 0:
+		a.add(AuditAmplification, SeverityInfo, "could not be determined, ANY and DNSKEY queries for %s failed", probe)
+	case worst > maxAmplification:
+		a.add(AuditAmplification, SeverityWarning, "responses for %s are up to %.1f times the query size", probe, worst)
+	default:
+		a.add(AuditAmplification, SeverityOK, "responses for %s are at most %.1f times the query size", probe, worst)
+	}
+}
+
+func txtStrings(rrs []dns.RR) []string {
+	var strs []string
+	for _, rr := range rrs {
+		if txt, ok := rr.(*dns.TXT); ok {
+			strs = append(strs, strings.Join(txt.Txt, ""))
+		}
+	}
+	return strs
+}
+
+// mixCase randomizes the case of the letters in name, making sure at least
+// one of them is upper case.
+func mixCase(name string) string {
+	b := []rune(strings.ToLower(name))
+	upper := false
+	for i, r := range b {
+		if unicode.IsLetter(r) && rand.Intn(2) == 1 {
+			b[i] = unicode.ToUpper(r)
+			upper = true
+		}
+	}
+	if !upper {
+		for i, r := range b {
+			if unicode.IsLetter(r) {
+				b[i] = unicode.ToUpper(r)
+				break
+			}
+		}
+	}
+	return string(b)
+}
+
+// isInternal reports whether ip is only meaningful inside a private network.
+func isInternal(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+func printResolverReport(report *ResolverReport) {
+	fmt.Printf("\n🩺 Resolver audit of %s\n", report.Resolver)
+	for _, amp := range report.Amplification {
+		fmt.Printf("  %-6s %4d → %4d bytes (%.1fx)", amp.Type, amp.QuerySize, amp.ResponseSize, amp.Factor)
+		if amp.Truncated {
+			fmt.Print(", capped at the UDP buffer size")
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+	printFindings(report.Findings)
+}
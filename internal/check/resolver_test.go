@@ -0,0 +1,208 @@
+package check
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"cDNS/internal/config"
+)
+
+var testConfig = config.Config{Timeout: time.Second, Retries: 1}
+
+// startServer serves handler on a local UDP port and returns its address.
+func startServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// stubResolver is a recursive resolver answering from fixed data: names
+// without an entry get an empty NOERROR response.
+type stubResolver struct {
+	refuse    bool
+	lowerCase bool
+	rcodes    map[string]int
+	addresses map[string]string
+	cnames    map[string]string
+	txt       map[string]string
+}
+
+func (s stubResolver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = true
+	name := strings.ToLower(r.Question[0].Name)
+	if s.lowerCase {
+		m.Question[0].Name = name
+	}
+	switch {
+	case s.refuse:
+		m.Rcode = dns.RcodeRefused
+	case s.rcodes[name] != 0:
+		m.Rcode = s.rcodes[name]
+	case s.txt[name] != "" && r.Question[0].Qtype == dns.TypeTXT:
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{s.txt[name]},
+		})
+	case s.cnames[name] != "":
+		target := s.cnames[name]
+		m.Answer = append(m.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 3600},
+			Target: target,
+		})
+		if s.addresses[target] != "" && r.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: target, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(s.addresses[target]),
+			})
+		}
+	case s.addresses[name] != "" && r.Question[0].Qtype == dns.TypeA:
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(s.addresses[name]),
+		})
+	}
+	w.WriteMsg(m)
+}
+
+func auditStub(t *testing.T, stub stubResolver) *ResolverReport {
+	t.Helper()
+	probes, err := ResolverProbes([]string{
+		"recursion=www.example.test",
+		"0x20=www.example.test",
+		"rebinding=private.example.test",
+		"dnssec=bogus.example.test",
+		"qname-min=qnamemin.example.test",
+		"ecs=ecs.example.test",
+		"amplification=example.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AuditResolver(startServer(t, stub), probes, testConfig)
+}
+
+func findingOf(t *testing.T, report *ResolverReport, check string) Finding {
+	t.Helper()
+	for _, f := range report.Findings {
+		if f.Check == check {
+			return f
+		}
+	}
+	t.Fatalf("no %s finding in %+v", check, report.Findings)
+	return Finding{}
+}
+
+func TestAuditResolverRefusesRecursion(t *testing.T) {
+	report := auditStub(t, stubResolver{refuse: true})
+	if f := findingOf(t, report, AuditRecursion); f.Severity != SeverityOK {
+		t.Errorf("recursion = %s %q, want ok", f.Severity, f.Message)
+	}
+	if len(report.Findings) != 1 {
+		t.Errorf("got %d findings, want only the recursion check: %+v", len(report.Findings), report.Findings)
+	}
+}
+
+func TestAuditResolverOpen(t *testing.T) {
+	report := auditStub(t, stubResolver{})
+	if f := findingOf(t, report, AuditRecursion); f.Severity != SeverityWarning {
+		t.Errorf("recursion = %s %q, want warning", f.Severity, f.Message)
+	}
+	if f := findingOf(t, report, Audit0x20); f.Severity != SeverityOK {
+		t.Errorf("0x20 = %s %q, want ok", f.Severity, f.Message)
+	}
+}
+
+func TestAuditResolver0x20NotPreserved(t *testing.T) {
+	report := auditStub(t, stubResolver{lowerCase: true})
+	if f := findingOf(t, report, Audit0x20); f.Severity != SeverityWarning {
+		t.Errorf("0x20 = %s %q, want warning", f.Severity, f.Message)
+	}
+}
+
+func TestAuditResolverRebinding(t *testing.T) {
+	tests := []struct {
+		name     string
+		stub     stubResolver
+		severity Severity
+	}{
+		{
+			name:     "private answer",
+			stub:     stubResolver{addresses: map[string]string{"private.example.test.": "192.168.0.1"}},
+			severity: SeverityWarning,
+		},
+		{
+			name:     "public answer",
+			stub:     stubResolver{addresses: map[string]string{"private.example.test.": "192.0.2.1"}},
+			severity: SeverityOK,
+		},
+		{
+			name:     "filtered",
+			stub:     stubResolver{},
+			severity: SeverityOK,
+		},
+		{
+			name:     "servfail",
+			stub:     stubResolver{rcodes: map[string]int{"private.example.test.": dns.RcodeServerFailure}},
+			severity: SeverityInfo,
+		},
+		{
+			name:     "nxdomain",
+			stub:     stubResolver{rcodes: map[string]int{"private.example.test.": dns.RcodeNameError}},
+			severity: SeverityInfo,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := auditStub(t, tt.stub)
+			if f := findingOf(t, report, AuditRebinding); f.Severity != tt.severity {
+				t.Errorf("rebinding = %s %q, want %s", f.Severity, f.Message, tt.severity)
+			}
+		})
+	}
+}
+
+func TestAuditResolverDNSSEC(t *testing.T) {
+	tests := []struct {
+		name     string
+		stub     stubResolver
+		severity Severity
+	}{
+		{
+			name:     "servfail for bogus name",
+			stub:     stubResolver{rcodes: map[string]int{"bogus.example.test.": dns.RcodeServerFailure}},
+			severity: SeverityOK,
+		},
+		{
+			name:     "bogus name answered",
+			stub:     stubResolver{addresses: map[string]string{"bogus.example.test.": "192.0.2.1"}},
+			severity: SeverityWarning,
+		},
+		{
+			name:     "no answer",
+			stub:     stubResolver{},
+			severity: SeverityInfo,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := auditStub(t, tt.stub)
+			if f := findingOf(t, report, AuditDNSSEC); f.Severity != tt.severity {
+				t.Errorf("dnssec = %s %q, want %s", f.Severity, f.Message, tt.severity)
+			}
+		})
+	}
+}
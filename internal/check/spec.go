@@ -0,0 +1,574 @@
+package check
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// Authoritative in a nameserver list stands for every authoritative server
+// of the tested zone.
+const Authoritative = "authoritative"
+
+// Test output formats.
+const (
+	FormatTAP   = "tap"
+	FormatJUnit = "junit"
+)
+
+// Spec is a file of DNS assertions. Zone and Nameservers are defaults for
+// tests that do not set their own.
+//
+//	nameservers: [authoritative]
+//	tests:
+//	  - name: web frontend
+//	    domain: www.example.com
+//	    type: A
+//	    exact: [203.0.113.5]
+//	    ttl: "<= 300"
+//	  - domain: example.com
+//	    type: MX
+//	    contains: [mx1]
+type Spec struct {
+	Zone        string     `yaml:"zone" json:"zone,omitempty"`
+	Nameservers []string   `yaml:"nameservers" json:"nameservers,omitempty"`
+	Tests       []SpecTest `yaml:"tests" json:"tests"`
+}
+
+// SpecTest asserts on the records of one type of a name. Record values are
+// compared in presentation format, case-insensitively and ignoring trailing
+// dots: Exact requires exactly these values, Contains requires each string
+// to occur in some value, Regex requires some value to match and Absent
+// requires no records. TTL is a comparison such as "<= 300" that every
+// record must satisfy.
+type SpecTest struct {
+	Name        string   `yaml:"name" json:"name,omitempty"`
+	Domain      string   `yaml:"domain" json:"domain"`
+	Type        string   `yaml:"type" json:"type,omitempty"`
+	Zone        string   `yaml:"zone" json:"zone,omitempty"`
+	Nameservers []string `yaml:"nameservers" json:"nameservers,omitempty"`
+	Exact       []string `yaml:"exact" json:"exact,omitempty"`
+	Contains    []string `yaml:"contains" json:"contains,omitempty"`
+	Regex       string   `yaml:"regex" json:"regex,omitempty"`
+	Absent      bool     `yaml:"absent" json:"absent,omitempty"`
+	TTL         string   `yaml:"ttl" json:"ttl,omitempty"`
+
+	regex *regexp.Regexp
+	ttl   *ttlCondition
+}
+
+// TestResult is the outcome of one test against one nameserver.
+type TestResult struct {
+	Name       string        `json:"name"`
+	Domain     string        `json:"domain"`
+	Type       string        `json:"type"`
+	Nameserver string        `json:"nameserver"`
+	Passed     bool          `json:"passed"`
+	Failures   []string      `json:"failures,omitempty"`
+	Values     []string      `json:"values,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+type TestReport struct {
+	Spec    string       `json:"spec"`
+	Results []TestResult `json:"results"`
+	Passed  int          `json:"passed"`
+	Failed  int          `json:"failed"`
+}
+
+type ttlCondition struct {
+	op    string
+	value uint32
+}
+
+var ttlPattern = regexp.MustCompile(`^\s*(<=|>=|==|=|<|>)?\s*(\d+)\s*$`)
+
+func RunTest(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	if format != FormatTAP && format != FormatJUnit {
+		logger.GetLogger().Fatal("Invalid output format", zap.String("format", format))
+	}
+	spec, err := LoadSpec(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to load test spec", zap.Error(err))
+	}
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	resolver, err := resolverFromFlags(cmd, cfg)
+	if err != nil {
+		logger.GetLogger().Fatal("No resolver available", zap.Error(err))
+	}
+
+	logger.GetLogger().Info("Running DNS tests", zap.String("spec", args[0]), zap.Int("tests", len(spec.Tests)))
+	report := RunSpec(spec, resolver, cfg)
+	report.Spec = args[0]
+	switch {
+	case cfg.JSONOutput:
+		jsonOutput(report, cfg)
+	case cfg.OutputFile != "":
+		f, err := os.Create(cfg.OutputFile)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to create output file", zap.Error(err))
+		}
+		writeTestReport(f, report, format)
+		if err := f.Close(); err != nil {
+			logger.GetLogger().Fatal("Failed to write output file", zap.Error(err))
+		}
+		fmt.Printf("Report written to: %s\n", cfg.OutputFile)
+	default:
+		writeTestReport(os.Stdout, report, format)
+	}
+	fmt.Fprintf(os.Stderr, "🧪 %d passed, %d failed\n", report.Passed, report.Failed)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// LoadSpec reads a test spec file. JSON files are accepted as well since
+// JSON is a subset of YAML.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(Spec)
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return spec, nil
+}
+
+func (s *Spec) validate() error {
+	if len(s.Tests) == 0 {
+		return fmt.Errorf("no tests defined")
+	}
+	for i := range s.Tests {
+		t := &s.Tests[i]
+		if t.Domain == "" {
+			return fmt.Errorf("test %d has no domain", i+1)
+		}
+		domain, err := ldns.ToASCII(t.Domain)
+		if err != nil {
+			return fmt.Errorf("test %d: %v", i+1, err)
+		}
+		t.Domain = dns.Fqdn(domain)
+		if t.Type == "" {
+			t.Type = "A"
+		}
+		t.Type = strings.ToUpper(t.Type)
+		if _, ok := ldns.RecordType(t.Type); !ok {
+			return fmt.Errorf("test %d has unsupported type %q", i+1, t.Type)
+		}
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("%s %s", t.Domain, t.Type)
+		}
+		if len(t.Exact) == 0 && len(t.Contains) == 0 && t.Regex == "" && !t.Absent && t.TTL == "" {
+			return fmt.Errorf("test %d (%s) has no assertions", i+1, t.Name)
+		}
+		if t.Absent && (len(t.Exact) > 0 || len(t.Contains) > 0 || t.Regex != "" || t.TTL != "") {
+			return fmt.Errorf("test %d (%s) combines absent with other assertions", i+1, t.Name)
+		}
+		if t.Regex != "" {
+			if t.regex, err = regexp.Compile(t.Regex); err != nil {
+				return fmt.Errorf("test %d (%s): %v", i+1, t.Name, err)
+			}
+		}
+		if t.TTL != "" {
+			m := ttlPattern.FindStringSubmatch(t.TTL)
+			if m == nil {
+				return fmt.Errorf("test %d (%s) has invalid ttl condition %q", i+1, t.Name, t.TTL)
+			}
+			value, err := strconv.ParseUint(m[2], 10, 32)
+			if err != nil {
+				return fmt.Errorf("test %d (%s) has invalid ttl condition %q", i+1, t.Name, t.TTL)
+			}
+			op := m[1]
+			if op == "" || op == "=" {
+				op = "=="
+			}
+			t.ttl = &ttlCondition{op: op, value: uint32(value)}
+		}
+	}
+	return nil
+}
+
+// RunSpec evaluates every test against each of its nameservers, using
+// resolver to find zones and their authoritative servers.
+func RunSpec(spec *Spec, resolver string, cfg config.Config) *TestReport {
+	report := &TestReport{}
+	servers := make(map[string][]string)
+	for _, t := range spec.Tests {
+		nameservers := t.Nameservers
+		if len(nameservers) == 0 {
+			nameservers = spec.Nameservers
+		}
+		if len(nameservers) == 0 {
+			nameservers = []string{resolver}
+		}
+		zone := t.Zone
+		if zone == "" {
+			zone = spec.Zone
+		}
+		for _, target := range expandNameservers(t, nameservers, zone, resolver, servers, cfg) {
+			result := runTest(t, target, cfg)
+			if result.Passed {
+				report.Passed++
+			} else {
+				report.Failed++
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report
+}
+
+// specTarget is a nameserver a test runs against. Entries that cannot be
+// used keep their spec name and the failure to report.
+type specTarget struct {
+	nameserver string
+	failure    string
+}
+
+// expandNameservers replaces Authoritative with the servers of the test's
+// zone, found once per zone and kept in servers, and resolves the other
+// entries like --nameserver. Entries yielding no usable server become
+// failing targets so they are reported instead of skipped.
+func expandNameservers(t SpecTest, nameservers []string, zone, resolver string, servers map[string][]string, cfg config.Config) []specTarget {
+	var expanded []specTarget
+	for _, ns := range nameservers {
+		if ns != Authoritative {
+			prepared := ldns.PrepareNameservers([]string{ns}, cfg.IPVersion)
+			if len(prepared) == 0 {
+				expanded = append(expanded, specTarget{nameserver: ns, failure: "invalid or unusable nameserver"})
+			}
+			for _, address := range prepared {
+				expanded = append(expanded, specTarget{nameserver: address})
+			}
+			continue
+		}
+		noServers := specTarget{nameserver: Authoritative, failure: "no authoritative nameservers found"}
+		if zone == "" {
+			var err error
+			if zone, err = zoneOf(t.Domain, resolver, cfg); err != nil {
+				logger.GetLogger().Warn("Failed to find zone", zap.String("domain", t.Domain), zap.Error(err))
+				expanded = append(expanded, noServers)
+				continue
+			}
+		}
+		zone = dns.Fqdn(zone)
+		if _, ok := servers[zone]; !ok {
+			addrs, err := authoritativeServers(zone, resolver, cfg)
+			if err != nil {
+				logger.GetLogger().Warn("Failed to find authoritative servers", zap.String("zone", zone), zap.Error(err))
+			}
+			servers[zone] = addrs
+		}
+		if len(servers[zone]) == 0 {
+			expanded = append(expanded, noServers)
+			continue
+		}
+		for _, address := range servers[zone] {
+			expanded = append(expanded, specTarget{nameserver: address})
+		}
+	}
+	return expanded
+}
+
+// zoneOf returns the zone name belongs to, taken from the owner of the SOA
+// record in the answer or authority section.
+func zoneOf(name, resolver string, cfg config.Config) (string, error) {
+	q := querier{cfg: cfg}
+	r, err := q.ask(resolver, name, dns.TypeSOA, true)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range append(r.Answer, r.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Hdr.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no SOA record found for %s", name)
+}
+
+func runTest(t SpecTest, target specTarget, cfg config.Config) TestResult {
+	result := TestResult{Name: t.Name, Domain: t.Domain, Type: t.Type, Nameserver: target.nameserver}
+	if target.failure != "" {
+		result.Failures = []string{target.failure}
+		return result
+	}
+	cfg.RecordFilter = []string{t.Type}
+	start := time.Now()
+	res := ldns.Nameserver(t.Domain, target.nameserver, cfg, nil)
+	result.Duration = time.Since(start)
+	result.Nameserver = res.Nameserver
+
+	// Only records of the tested type count; CNAMEs leading to them do not.
+	var records []ldns.ParsedRecord
+	for _, record := range res.Records[t.Type] {
+		if record.Type == t.Type {
+			records = append(records, record)
+			result.Values = append(result.Values, recordValue(record))
+		}
+	}
+	if errMsg, failed := res.Errors[t.Type]; failed {
+		// A name that does not exist satisfies absent.
+		if !t.Absent || !strings.Contains(errMsg, dns.RcodeToString[dns.RcodeNameError]) {
+			result.Failures = append(result.Failures, fmt.Sprintf("query failed: %s", errMsg))
+		}
+	}
+	if len(result.Failures) == 0 {
+		result.Failures = t.evaluate(records, result.Values)
+	}
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+func (t SpecTest) evaluate(records []ldns.ParsedRecord, values []string) []string {
+	var failures []string
+	normalized := make([]string, len(values))
+	for i, v := range values {
+		normalized[i] = normalizeValue(v)
+	}
+	if t.Absent && len(records) > 0 {
+		failures = append(failures, fmt.Sprintf("expected no %s records, got %s", t.Type, quoteList(values)))
+	}
+	if len(t.Exact) > 0 {
+		want := make(map[string]int)
+		for _, v := range t.Exact {
+			want[normalizeValue(v)]++
+		}
+		got := make(map[string]int)
+		for _, v := range normalized {
+			got[v]++
+		}
+		if !equalCounts(want, got) {
+			failures = append(failures, fmt.Sprintf("expected exactly %s, got %s", quoteList(t.Exact), quoteList(values)))
+		}
+	}
+	for _, want := range t.Contains {
+		found := false
+		for _, v := range normalized {
+			if strings.Contains(v, normalizeValue(want)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("no value contains %q, got %s", want, quoteList(values)))
+		}
+	}
+	if t.regex != nil {
+		matched := false
+		for _, v := range values {
+			if t.regex.MatchString(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			failures = append(failures, fmt.Sprintf("no value matches /%s/, got %s", t.Regex, quoteList(values)))
+		}
+	}
+	if t.ttl != nil {
+		if len(records) == 0 {
+			failures = append(failures, fmt.Sprintf("expected TTL %s %d, got no %s records", t.ttl.op, t.ttl.value, t.Type))
+		}
+		for i, record := range records {
+			if !t.ttl.match(record.TTL) {
+				failures = append(failures, fmt.Sprintf("TTL %d of %q is not %s %d", record.TTL, values[i], t.ttl.op, t.ttl.value))
+			}
+		}
+	}
+	return failures
+}
+
+func (c *ttlCondition) match(ttl uint32) bool {
+	switch c.op {
+	case "<":
+		return ttl < c.value
+	case "<=":
+		return ttl <= c.value
+	case ">":
+		return ttl > c.value
+	case ">=":
+		return ttl >= c.value
+	}
+	return ttl == c.value
+}
+
+// recordValue renders the data of a record in presentation format.
+func recordValue(r ldns.ParsedRecord) string {
+	switch r.Type {
+	case "A", "AAAA", "CNAME":
+		return r.Address
+	case "NS", "PTR":
+		return r.Host
+	case "MX":
+		return fmt.Sprintf("%d %s", r.Pref, r.Host)
+	case "TXT":
+		return r.Text
+	case "SRV":
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case "SOA":
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.MName, r.RName, r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum)
+	case "CAA":
+		return fmt.Sprintf("%d %s %q", r.Flag, r.Tag, r.Value)
+	case "NSEC":
+		return strings.TrimSpace(r.Target + " " + strings.Join(r.Types, " "))
+	}
+	// Other types carry the whole record; drop owner, TTL, class and type.
+	fields := strings.Fields(r.RawData)
+	if len(fields) > 4 {
+		return strings.Join(fields[4:], " ")
+	}
+	return r.RawData
+}
+
+func normalizeValue(v string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v)), ".")
+}
+
+func equalCounts(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, n := range a {
+		if b[k] != n {
+			return false
+		}
+	}
+	return true
+}
+
+func quoteList(values []string) string {
+	if len(values) == 0 {
+		return "nothing"
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func writeTestReport(f *os.File, report *TestReport, format string) {
+	if format == FormatJUnit {
+		writeJUnit(f, report)
+		return
+	}
+	writeTAP(f, report)
+}
+
+// writeTAP writes TAP version 13 with YAML diagnostics for failed tests.
+func writeTAP(f *os.File, report *TestReport) {
+	fmt.Fprintln(f, "TAP version 13")
+	fmt.Fprintf(f, "1..%d\n", len(report.Results))
+	for i, r := range report.Results {
+		status := "ok"
+		if !r.Passed {
+			status = "not ok"
+		}
+		fmt.Fprintf(f, "%s %d - %s @ %s\n", status, i+1, r.Name, r.Nameserver)
+		if r.Passed {
+			continue
+		}
+		diag, _ := yaml.Marshal(map[string]interface{}{
+			"domain":   r.Domain,
+			"type":     r.Type,
+			"failures": r.Failures,
+			"values":   r.Values,
+		})
+		fmt.Fprintln(f, "  ---")
+		for _, line := range strings.Split(strings.TrimRight(string(diag), "\n"), "\n") {
+			fmt.Fprintf(f, "  %s\n", line)
+		}
+		fmt.Fprintln(f, "  ...")
+	}
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeJUnit(f *os.File, report *TestReport) {
+	suite := junitSuite{Name: report.Spec, Tests: len(report.Results), Failures: report.Failed}
+	var total time.Duration
+	for _, r := range report.Results {
+		total += r.Duration
+		c := junitCase{
+			Name:      fmt.Sprintf("%s @ %s", r.Name, r.Nameserver),
+			Classname: fmt.Sprintf("%s %s", r.Domain, r.Type),
+			Time:      seconds(r.Duration),
+		}
+		if !r.Passed {
+			c.Failure = &junitFailure{
+				Message: r.Failures[0],
+				Text:    strings.Join(r.Failures, "\n") + "\nvalues: " + quoteList(r.Values),
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Time = seconds(total)
+	out, err := xml.MarshalIndent(junitSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}, "", "  ")
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to marshal JUnit report", zap.Error(err))
+	}
+	fmt.Fprintf(f, "%s%s\n", xml.Header, out)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,70 @@
+package check
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"cDNS/internal/logger"
+)
+
+func runSpec(t *testing.T, spec *Spec, resolver string) *TestReport {
+	t.Helper()
+	logger.Logger = zap.NewNop()
+	if err := spec.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig
+	cfg.IPVersion = 4
+	return RunSpec(spec, resolver, cfg)
+}
+
+func TestRunSpecReportsUnusableNameservers(t *testing.T) {
+	addr := startServer(t, stubResolver{addresses: map[string]string{"www.example.test.": "192.0.2.10"}})
+	unusable := []string{"ftp://192.0.2.1", "@no-such-group", "[2001:db8::1]:53"}
+	spec := &Spec{Tests: []SpecTest{{
+		Domain:      "www.example.test",
+		Nameservers: append([]string{addr}, unusable...),
+		Exact:       []string{"192.0.2.10"},
+	}}}
+
+	report := runSpec(t, spec, addr)
+	if report.Passed != 1 || report.Failed != len(unusable) {
+		t.Fatalf("passed %d, failed %d; want 1 and %d", report.Passed, report.Failed, len(unusable))
+	}
+	for i, ns := range unusable {
+		result := report.Results[i+1]
+		if result.Nameserver != ns || result.Passed || len(result.Failures) == 0 {
+			t.Errorf("result for %s = %+v, want a failure", ns, result)
+		}
+	}
+}
+
+func TestRunSpecFailsWithoutNameservers(t *testing.T) {
+	spec := &Spec{Tests: []SpecTest{{
+		Domain:      "www.example.test",
+		Nameservers: []string{"ftp://192.0.2.1"},
+		Absent:      true,
+	}}}
+	report := runSpec(t, spec, "127.0.0.1:1")
+	if report.Passed != 0 || report.Failed != 1 {
+		t.Errorf("passed %d, failed %d; want 0 and 1", report.Passed, report.Failed)
+	}
+}
+
+func TestRunSpecIgnoresCNAMEs(t *testing.T) {
+	addr := startServer(t, stubResolver{
+		cnames:    map[string]string{"www.example.test.": "web.example.test."},
+		addresses: map[string]string{"web.example.test.": "192.0.2.10"},
+	})
+	spec := &Spec{Nameservers: []string{addr}, Tests: []SpecTest{
+		{Domain: "www.example.test", Exact: []string{"192.0.2.10"}, TTL: "<= 300"},
+		{Domain: "www.example.test", Type: "CNAME", Exact: []string{"web.example.test"}},
+	}}
+	report := runSpec(t, spec, addr)
+	for _, result := range report.Results {
+		if !result.Passed {
+			t.Errorf("%s failed: %v", result.Name, result.Failures)
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+	"cDNS/internal/logger"
+)
+
+// SOA timer ranges recommended by RFC 1912 section 2.2 and RFC 2308.
+const (
+	minRefresh = 1200
+	maxRefresh = 43200
+	minExpire  = 604800
+	maxExpire  = 2419200
+	maxMinTTL  = 86400
+)
+
+// ZoneReport is the result of auditing a zone's delegation and servers.
+type ZoneReport struct {
+	Zone     string            `json:"zone"`
+	Parent   string            `json:"parent,omitempty"`
+	ParentNS []string          `json:"parent_ns"`
+	ChildNS  []string          `json:"child_ns"`
+	Serials  map[string]uint32 `json:"serials,omitempty"`
+	Findings []Finding         `json:"findings"`
+	Summary  map[string]int    `json:"summary"`
+}
+
+// server is an authoritative address that answered for the zone.
+type server struct {
+	name    string
+	address string
+}
+
+func (s server) String() string {
+	return fmt.Sprintf("%s (%s)", s.name, s.address)
+}
+
+// querier sends queries with the retries and binding of cfg.
+type querier struct {
+	cfg config.Config
+}
+
+type zoneChecker struct {
+	querier
+	resolver string
+	report   *ZoneReport
+	glue     map[string][]string
+	addrs    map[string][]string
+	servers  []server
+	soa      *dns.SOA
+}
+
+func RunCheckZone(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	zone, err := ldns.ToASCII(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid zone", zap.Error(err))
+	}
+	zone = dns.Fqdn(zone)
+	if err := ldns.ValidateDomain(zone, ldns.ModeDNSName); err != nil {
+		logger.GetLogger().Fatal("Invalid zone", zap.Error(err))
+	}
+	if err := ldns.ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	resolver, err := resolverFromFlags(cmd, cfg)
+	if err != nil {
+		logger.GetLogger().Fatal("No resolver available", zap.Error(err))
+	}
+
+	logger.GetLogger().Info("Checking zone", zap.String("zone", zone), zap.String("resolver", resolver))
+	report := Zone(zone, resolver, cfg)
+	if cfg.JSONOutput {
+		jsonOutput(report, cfg)
+	} else {
+		printZoneReport(report)
+	}
+	if Failed(report.Findings) {
+		os.Exit(1)
+	}
+}
+
+// resolverFromFlags returns the recursive resolver given by --resolver, or
+// the first nameserver in resolv.conf.
+func resolverFromFlags(cmd *cobra.Command, cfg config.Config) (string, error) {
+	resolver, _ := cmd.Flags().GetString("resolver")
+	if resolver == "" {
+		rc, err := ldns.LoadResolvConf(ldns.DefaultResolvConf)
+		if err != nil {
+			return "", err
+		}
+		resolver = rc.Nameservers[0]
+	}
+	prepared := ldns.PrepareNameservers([]string{resolver}, cfg.IPVersion)
+	if len(prepared) == 0 {
+		return "", fmt.Errorf("invalid resolver %q", resolver)
+	}
+	return prepared[0], nil
+}
+
+// Zone audits the delegation of zone from its parent and every authoritative
+// server, using resolver for recursive lookups.
+func Zone(zone, resolver string, cfg config.Config) *ZoneReport {
+	c := &zoneChecker{
+		querier:  querier{cfg: cfg},
+		resolver: resolver,
+		report:   &ZoneReport{Zone: zone, Serials: make(map[string]uint32)},
+		glue:     make(map[string][]string),
+		addrs:    make(map[string][]string),
+	}
+	if c.checkParent() {
+		c.checkChild()
+		c.checkGlue()
+		c.checkServers()
+		c.checkSerials()
+		c.checkSOATimers()
+		c.checkDiversity()
+	}
+	Rank(c.report.Findings)
+	c.report.Summary = Summarize(c.report.Findings)
+	return c.report
+}
+
+func (c *zoneChecker) add(check string, severity Severity, server, format string, args ...interface{}) {
+	c.report.Findings = append(c.report.Findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Server:   server,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (q querier) exchange(nameserver string, m *dns.Msg) (*dns.Msg, error) {
+	var err error
+	for attempt := 0; attempt < max(q.cfg.Retries, 1); attempt++ {
+		var r *dns.Msg
+		if r, _, err = ldns.Exchange(m, nameserver, q.cfg, nil); err == nil {
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+func (q querier) ask(nameserver, name string, qtype uint16, recurse bool) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = recurse
+	return q.exchange(nameserver, m)
+}
+
+// lookup resolves the addresses of name through the recursive resolver,
+// limited to the configured address family.
+func (c *zoneChecker) lookup(name string) []string {
+	var addrs []string
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if (qtype == dns.TypeA && c.cfg.IPVersion == 6) || (qtype == dns.TypeAAAA && c.cfg.IPVersion == 4) {
+			continue
+		}
+		r, err := c.ask(c.resolver, name, qtype, true)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addresses(r.Answer, name)...)
+	}
+	return addrs
+}
+
+// nameserverAddrs returns the addresses used to reach a nameserver: glue from
+// the parent when present, otherwise the resolver's answer.
+func (c *zoneChecker) nameserverAddrs(name string) []string {
+	if addrs, ok := c.addrs[name]; ok {
+		return addrs
+	}
+	var addrs []string
+	for _, glue := range c.glue[name] {
+		if addr, err := netip.ParseAddr(glue); err == nil && (c.cfg.IPVersion == 0 || (c.cfg.IPVersion == 4) == addr.Is4()) {
+			addrs = append(addrs, glue)
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = c.lookup(name)
+	}
+	if len(addrs) == 0 {
+		c.add("ns_address", SeverityError, name, "nameserver has no usable addresses")
+	}
+	c.addrs[name] = addrs
+	return addrs
+}
+
+// checkParent finds the parent zone and the delegation it publishes. It
+// reports whether there is a delegation to check further.
+func (c *zoneChecker) checkParent() bool {
+	zone := c.report.Zone
+	if zone == "." {
+		c.add("parent", SeverityError, "", "the root zone has no parent delegation")
+		return false
+	}
+	var parentNS []string
+	for name := parentZone(zone); ; name = parentZone(name) {
+		r, err := c.ask(c.resolver, name, dns.TypeNS, true)
+		if err != nil {
+			c.add("parent", SeverityError, c.resolver, "looking up NS for %s: %v", name, err)
+			return false
+		}
+		if parentNS = nsNames(r.Answer, name); len(parentNS) > 0 {
+			c.report.Parent = name
+			break
+		}
+		if name == "." {
+			c.add("parent", SeverityError, c.resolver, "no parent zone found")
+			return false
+		}
+	}
+
+	sets := make(map[string][]string)
+	for _, name := range parentNS {
+		addrs := c.lookup(name)
+		if len(addrs) == 0 {
+			c.add("parent", SeverityWarning, name, "parent nameserver has no usable addresses")
+		}
+		for _, addr := range addrs {
+			label := server{name, addr}.String()
+			r, err := c.ask(net.JoinHostPort(addr, "53"), zone, dns.TypeNS, false)
+			if err != nil {
+				c.add("parent", SeverityWarning, label, "parent server did not respond: %v", err)
+				continue
+			}
+			if r.Rcode == dns.RcodeNameError {
+				c.add("delegation", SeverityError, label, "parent reports that %s does not exist", zone)
+				continue
+			}
+			if r.Rcode != dns.RcodeSuccess {
+				c.add("parent", SeverityWarning, label, "parent server answered %s", dns.RcodeToString[r.Rcode])
+				continue
+			}
+			ns := nsNames(append(r.Answer, r.Ns...), zone)
+			if len(ns) == 0 {
+				c.add("delegation", SeverityError, label, "parent server returned no NS records for %s", zone)
+				continue
+			}
+			sets[label] = ns
+			for _, rr := range r.Extra {
+				owner := dns.CanonicalName(rr.Header().Name)
+				for _, addr := range addresses([]dns.RR{rr}, owner) {
+					if !slices.Contains(c.glue[owner], addr) {
+						c.glue[owner] = append(c.glue[owner], addr)
+					}
+				}
+			}
+		}
+	}
+	c.report.ParentNS = union(sets)
+	if len(c.report.ParentNS) == 0 {
+		c.add("delegation", SeverityError, "", "no parent server returned a delegation for %s", zone)
+		return false
+	}
+	consistent := true
+	for label, ns := range sets {
+		if !slices.Equal(ns, c.report.ParentNS) {
+			consistent = false
+			c.add("parent_ns", SeverityWarning, label, "parent server lists %s, other parent servers list %s",
+				strings.Join(ns, ", "), strings.Join(c.report.ParentNS, ", "))
+		}
+	}
+	if consistent {
+		c.add("parent_ns", SeverityOK, "", "parent servers agree on the delegation (%d queried)", len(sets))
+	}
+	return true
+}
+
+// checkChild queries every delegated nameserver for the zone's NS set,
+// flagging lame servers and differences from the parent's delegation.
+func (c *zoneChecker) checkChild() {
+	zone := c.report.Zone
+	sets := make(map[string][]string)
+	lame := 0
+	probe := func(names []string) {
+		for _, name := range names {
+			for _, addr := range c.nameserverAddrs(name) {
+				s := server{name, addr}
+				r, err := c.ask(net.JoinHostPort(addr, "53"), zone, dns.TypeNS, false)
+				switch {
+				case err != nil:
+					lame++
+					c.add("lame", SeverityError, s.String(), "no response: %v", err)
+				case r.Rcode != dns.RcodeSuccess:
+					lame++
+					c.add("lame", SeverityError, s.String(), "answered %s instead of serving the zone", dns.RcodeToString[r.Rcode])
+				case !r.Authoritative:
+					lame++
+					c.add("lame", SeverityError, s.String(), "answer is not authoritative")
+				default:
+					c.servers = append(c.servers, s)
+					sets[s.String()] = nsNames(r.Answer, zone)
+				}
+			}
+		}
+	}
+	probe(c.report.ParentNS)
+	c.report.ChildNS = union(sets)
+	var extra []string
+	for _, name := range c.report.ChildNS {
+		if !slices.Contains(c.report.ParentNS, name) {
+			extra = append(extra, name)
+		}
+	}
+	probe(extra)
+	if lame == 0 && len(c.servers) > 0 {
+		c.add("lame", SeverityOK, "", "all nameserver addresses answer authoritatively (%d queried)", len(c.servers))
+	}
+	if len(c.servers) == 0 {
+		return
+	}
+
+	match := true
+	for _, name := range c.report.ParentNS {
+		if !slices.Contains(c.report.ChildNS, name) {
+			match = false
+			c.add("ns_match", SeverityError, name, "delegated by the parent but missing from the zone's NS set")
+		}
+	}
+	for _, name := range extra {
+		match = false
+		c.add("ns_match", SeverityWarning, name, "listed in the zone's NS set but not delegated by the parent")
+	}
+	for label, ns := range sets {
+		if !slices.Equal(ns, c.report.ChildNS) {
+			match = false
+			c.add("ns_match", SeverityError, label, "serves NS set %s, other servers serve %s",
+				strings.Join(ns, ", "), strings.Join(c.report.ChildNS, ", "))
+		}
+	}
+	if match {
+		c.add("ns_match", SeverityOK, "", "parent and zone NS sets match")
+	}
+}
+
+// checkGlue verifies that in-zone nameservers have glue at the parent and
+// that it matches the addresses published in the zone.
+func (c *zoneChecker) checkGlue() {
+	if len(c.servers) == 0 {
+		return
+	}
+	auth := net.JoinHostPort(c.servers[0].address, "53")
+	ok := true
+	for _, name := range c.report.ParentNS {
+		if !dns.IsSubDomain(c.report.Zone, name) {
+			continue
+		}
+		glue := slices.Clone(c.glue[name])
+		if len(glue) == 0 {
+			ok = false
+			c.add("glue", SeverityError, name, "in-zone nameserver has no glue at the parent")
+			continue
+		}
+		var published []string
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if r, err := c.ask(auth, name, qtype, false); err == nil {
+				published = append(published, addresses(r.Answer, name)...)
+			}
+		}
+		sort.Strings(glue)
+		sort.Strings(published)
+		if !slices.Equal(glue, published) {
+			ok = false
+			c.add("glue", SeverityError, name, "parent glue %s does not match the zone's addresses %s",
+				strings.Join(glue, ", "), strings.Join(published, ", "))
+		}
+	}
+	if ok {
+		c.add("glue", SeverityOK, "", "glue is present and correct")
+	}
+}
+
+// checkServers runs the per-server checks: SOA serial, recursion, TCP and
+// EDNS compliance.
+func (c *zoneChecker) checkServers() {
+	zone := c.report.Zone
+	probe := "example.com."
+	if dns.IsSubDomain(probe, zone) {
+		probe = "example.net."
+	}
+	for _, s := range c.servers {
+		address := net.JoinHostPort(s.address, "53")
+		if r, err := c.ask(address, zone, dns.TypeSOA, false); err != nil {
+			c.add("soa", SeverityError, s.String(), "SOA query failed: %v", err)
+		} else if soa := soaRecord(r.Answer, zone); soa == nil {
+			c.add("soa", SeverityError, s.String(), "no SOA record returned")
+		} else {
+			c.report.Serials[s.String()] = soa.Serial
+			if c.soa == nil {
+				c.soa = soa
+			}
+		}
+
+		r, err := c.ask(address, probe, dns.TypeA, true)
+		switch {
+		case err != nil:
+		case len(r.Answer) > 0:
+			c.add("recursion", SeverityError, s.String(), "resolves names outside its zones (open recursion)")
+		case r.RecursionAvailable:
+			c.add("recursion", SeverityWarning, s.String(), "advertises recursion available")
+		default:
+			c.add("recursion", SeverityOK, s.String(), "recursion is disabled")
+		}
+
+		if _, err := c.ask("tcp://"+address, zone, dns.TypeSOA, false); err != nil {
+			c.add("tcp", SeverityError, s.String(), "no answer over TCP: %v", err)
+		} else {
+			c.add("tcp", SeverityOK, s.String(), "answers over TCP")
+		}
+
+		c.checkEDNS(s, address)
+	}
+}
+
+// checkEDNS sends a plain EDNS query and an EDNS version 1 query, which a
+// compliant server answers with BADVERS (RFC 6891 section 6.1.3).
+func (c *zoneChecker) checkEDNS(s server, address string) {
+	m := new(dns.Msg)
+	m.SetQuestion(c.report.Zone, dns.TypeSOA)
+	m.RecursionDesired = false
+	m.SetEdns0(1232, false)
+	r, err := c.exchange(address, m)
+	if err != nil {
+		c.add("edns", SeverityError, s.String(), "no answer to an EDNS query: %v", err)
+		return
+	}
+	if r.IsEdns0() == nil {
+		c.add("edns", SeverityWarning, s.String(), "no OPT record in the response, EDNS is not supported")
+		return
+	}
+
+	m = m.Copy()
+	m.Id = dns.Id()
+	m.IsEdns0().SetVersion(1)
+	r, err = c.exchange(address, m)
+	if err != nil {
+		c.add("edns", SeverityWarning, s.String(), "no answer to an EDNS version 1 query: %v", err)
+		return
+	}
+	if r.Rcode != dns.RcodeBadVers || r.IsEdns0() == nil {
+		c.add("edns", SeverityWarning, s.String(), "EDNS version 1 query answered %s instead of BADVERS", dns.RcodeToString[r.Rcode])
+		return
+	}
+	c.add("edns", SeverityOK, s.String(), "EDNS compliant")
+}
+
+func (c *zoneChecker) checkSerials() {
+	serials := make(map[uint32][]string)
+	for label, serial := range c.report.Serials {
+		serials[serial] = append(serials[serial], label)
+	}
+	switch len(serials) {
+	case 0:
+		return
+	case 1:
+		for serial := range serials {
+			c.add("serial", SeverityOK, "", "all servers serve serial %d", serial)
+		}
+	default:
+		var parts []string
+		for serial, labels := range serials {
+			sort.Strings(labels)
+			parts = append(parts, fmt.Sprintf("%d on %s", serial, strings.Join(labels, ", ")))
+		}
+		sort.Strings(parts)
+		c.add("serial", SeverityError, "", "SOA serials differ: %s", strings.Join(parts, "; "))
+	}
+}
+
+func (c *zoneChecker) checkSOATimers() {
+	soa := c.soa
+	if soa == nil {
+		return
+	}
+	ok := true
+	warn := func(format string, args ...interface{}) {
+		ok = false
+		c.add("soa_timers", SeverityWarning, "", format, args...)
+	}
+	if soa.Refresh < minRefresh || soa.Refresh > maxRefresh {
+		warn("refresh %d is outside the recommended %d-%d seconds", soa.Refresh, minRefresh, maxRefresh)
+	}
+	if soa.Retry >= soa.Refresh {
+		warn("retry %d should be lower than refresh %d", soa.Retry, soa.Refresh)
+	}
+	if soa.Expire <= soa.Refresh+soa.Retry {
+		ok = false
+		c.add("soa_timers", SeverityError, "", "expire %d is not longer than refresh plus retry", soa.Expire)
+	} else if soa.Expire < minExpire || soa.Expire > maxExpire {
+		warn("expire %d is outside the recommended %d-%d seconds", soa.Expire, minExpire, maxExpire)
+	}
+	if soa.Minttl > maxMinTTL {
+		warn("negative caching TTL %d is longer than a day (RFC 2308 recommends 1-3 hours)", soa.Minttl)
+	}
+	if ok {
+		c.add("soa_timers", SeverityOK, "", "refresh %d, retry %d, expire %d, minimum %d", soa.Refresh, soa.Retry, soa.Expire, soa.Minttl)
+	}
+}
+
+// checkDiversity requires at least two nameservers reachable through
+// different networks (/24 for IPv4, /48 for IPv6).
+func (c *zoneChecker) checkDiversity() {
+	names := union(map[string][]string{"parent": c.report.ParentNS, "child": c.report.ChildNS})
+	if len(names) < 2 {
+		c.add("ns_count", SeverityError, "", "only %d nameserver, at least two are required (RFC 1034)", len(names))
+	} else {
+		c.add("ns_count", SeverityOK, "", "%d nameservers", len(names))
+	}
+	networks := make(map[netip.Prefix]bool)
+	for _, name := range names {
+		for _, a := range c.addrs[name] {
+			addr, err := netip.ParseAddr(a)
+			if err != nil {
+				continue
+			}
+			bits := 48
+			if addr.Is4() {
+				bits = 24
+			}
+			prefix, _ := addr.Prefix(bits)
+			networks[prefix] = true
+		}
+	}
+	if len(networks) < 2 {
+		c.add("diversity", SeverityWarning, "", "all nameservers share one network, use at least two different /24 (IPv4) or /48 (IPv6) networks")
+	} else {
+		c.add("diversity", SeverityOK, "", "nameservers are spread over %d networks", len(networks))
+	}
+}
+
+func printZoneReport(report *ZoneReport) {
+	fmt.Printf("\n🩺 Zone health for %s", report.Zone)
+	if report.Parent != "" {
+		fmt.Printf(" (parent %s)", report.Parent)
+	}
+	fmt.Println()
+	if len(report.ParentNS) > 0 {
+		fmt.Printf("  Parent NS: %s\n", strings.Join(report.ParentNS, ", "))
+	}
+	if len(report.ChildNS) > 0 {
+		fmt.Printf("  Zone NS:   %s\n", strings.Join(report.ChildNS, ", "))
+	}
+	fmt.Println()
+	printFindings(report.Findings)
+}
+
+// parentZone returns the name one label above name.
+func parentZone(name string) string {
+	i, end := dns.NextLabel(name, 0)
+	if end || i >= len(name) {
+		return "."
+	}
+	return name[i:]
+}
+
+// nsNames returns the sorted, lowercased NS targets owned by name.
+func nsNames(rrs []dns.RR, name string) []string {
+	var names []string
+	for _, rr := range rrs {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, name) {
+			target := dns.CanonicalName(ns.Ns)
+			if !slices.Contains(names, target) {
+				names = append(names, target)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func addresses(rrs []dns.RR, name string) []string {
+	var addrs []string
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, rr.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, rr.AAAA.String())
+		}
+	}
+	return addrs
+}
+
+func soaRecord(rrs []dns.RR, zone string) *dns.SOA {
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, zone) {
+			return soa
+		}
+	}
+	return nil
+}
+
+func union(sets map[string][]string) []string {
+	var all []string
+	for _, set := range sets {
+		for _, name := range set {
+			if !slices.Contains(all, name) {
+				all = append(all, name)
+			}
+		}
+	}
+	sort.Strings(all)
+	return all
+}
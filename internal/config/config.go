@@ -14,6 +14,13 @@ type Config struct {
 	OutputFile    string
 	APIPort       int
 	LogLevel      string
+	Search        []string
+	Ndots         int
+	CatalogFile   string
+	IPVersion     int
+	SourceAddress string
+	Interface     string
+	Follow        bool
 }
 
 func AddGlobalFlags(cmd *cobra.Command) {
@@ -24,6 +31,12 @@ func AddGlobalFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringSliceP("filter", "f", []string{}, "Filter specific record types")
 	cmd.PersistentFlags().StringP("output", "o", "", "Output file")
 	cmd.PersistentFlags().StringP("log-level", "l", "info", "Log level (debug, info, warn, error)")
+	cmd.PersistentFlags().BoolP("ipv4", "4", false, "Only use IPv4 to reach nameservers")
+	cmd.PersistentFlags().BoolP("ipv6", "6", false, "Only use IPv6 to reach nameservers")
+	cmd.MarkFlagsMutuallyExclusive("ipv4", "ipv6")
+	cmd.PersistentFlags().String("source", "", "Source address for outgoing queries (ip or ip:port)")
+	cmd.PersistentFlags().String("interface", "", "Network interface for outgoing queries")
+	cmd.PersistentFlags().String("catalog", "", "Resolver catalog file (YAML or JSON) merged with the built-in one")
 }
 
 func GetConfigFromFlags(cmd *cobra.Command) Config {
@@ -34,6 +47,17 @@ func GetConfigFromFlags(cmd *cobra.Command) Config {
 	filter, _ := cmd.Flags().GetStringSlice("filter")
 	output, _ := cmd.Flags().GetString("output")
 	logLevel, _ := cmd.Flags().GetString("log-level")
+	catalogFile, _ := cmd.Flags().GetString("catalog")
+	ipv4, _ := cmd.Flags().GetBool("ipv4")
+	ipv6, _ := cmd.Flags().GetBool("ipv6")
+	source, _ := cmd.Flags().GetString("source")
+	iface, _ := cmd.Flags().GetString("interface")
+	ipVersion := 0
+	if ipv4 {
+		ipVersion = 4
+	} else if ipv6 {
+		ipVersion = 6
+	}
 
 	return Config{
 		Timeout:       timeout,
@@ -43,5 +67,9 @@ func GetConfigFromFlags(cmd *cobra.Command) Config {
 		RecordFilter:  filter,
 		OutputFile:    output,
 		LogLevel:      logLevel,
+		CatalogFile:   catalogFile,
+		IPVersion:     ipVersion,
+		SourceAddress: source,
+		Interface:     iface,
 	}
 }
@@ -0,0 +1,366 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	"cDNS/internal/dnstap"
+	"cDNS/internal/logger"
+	"cDNS/internal/pcap"
+)
+
+// AnalyzeReport summarizes the DNS traffic of a pcap or dnstap file.
+type AnalyzeReport struct {
+	File               string        `json:"file"`
+	Format             string        `json:"format"`
+	Start              time.Time     `json:"start"`
+	End                time.Time     `json:"end"`
+	Messages           int           `json:"messages"`
+	Malformed          int           `json:"malformed"`
+	Queries            int           `json:"queries"`
+	Responses          int           `json:"responses"`
+	Answered           int           `json:"answered"`
+	Unanswered         int           `json:"unanswered"`
+	UnmatchedResponses int           `json:"unmatched_responses"`
+	TopNames           []NameCount   `json:"top_names"`
+	Rcodes             []NameCount   `json:"rcodes"`
+	RecordTypes        []TypeCount   `json:"record_types"`
+	Slowest            []Transaction `json:"slowest"`
+	UnansweredQueries  []Transaction `json:"unanswered_queries"`
+}
+
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// TypeCount counts the questions asking for a record type and the answer
+// records of that type.
+type TypeCount struct {
+	Type    string `json:"type"`
+	Queries int    `json:"queries"`
+	Answers int    `json:"answers"`
+}
+
+// Transaction is a query and, once paired, its response. Responses are
+// paired with queries by message ID and the client and server addresses.
+type Transaction struct {
+	Time     time.Time      `json:"time"`
+	Client   string         `json:"client"`
+	Server   string         `json:"server"`
+	ID       uint16         `json:"id"`
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`
+	Rcode    string         `json:"rcode,omitempty"`
+	Duration time.Duration  `json:"duration,omitempty"`
+	Answers  []ParsedRecord `json:"answers,omitempty"`
+}
+
+type transactionKey struct {
+	client, server netip.AddrPort
+	id             uint16
+}
+
+type analyzer struct {
+	report   AnalyzeReport
+	pending  map[transactionKey][]*Transaction
+	queries  []*Transaction
+	answered []*Transaction
+	names    map[string]int
+	rcodes   map[string]int
+	types    map[string]*TypeCount
+}
+
+func Analyze(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	top, _ := cmd.Flags().GetInt("top")
+	report, err := AnalyzeFile(args[0], top)
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to analyze capture", zap.Error(err))
+	}
+	if !cfg.JSONOutput {
+		printAnalyzeReport(report)
+		return
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to marshal report", zap.Error(err))
+	}
+	if cfg.OutputFile != "" {
+		if err := os.WriteFile(cfg.OutputFile, out, 0644); err != nil {
+			logger.GetLogger().Fatal("Failed to write output file", zap.Error(err))
+		}
+		fmt.Printf("Report written to: %s\n", cfg.OutputFile)
+	} else {
+		fmt.Println(string(out))
+	}
+}
+
+// AnalyzeFile reads the DNS messages of a pcap or dnstap file, telling them
+// apart by their first bytes, and keeps top entries in each ranking.
+func AnalyzeFile(path string, top int) (*AnalyzeReport, error) {
+	a := &analyzer{
+		report:  AnalyzeReport{File: path},
+		pending: make(map[transactionKey][]*Transaction),
+		names:   make(map[string]int),
+		rcodes:  make(map[string]int),
+		types:   make(map[string]*TypeCount),
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if bytes.Equal(magic, []byte{0, 0, 0, 0}) {
+		a.report.Format = "dnstap"
+		err = a.readDnstap(path)
+	} else {
+		a.report.Format = "pcap"
+		err = a.readPcap(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.finish(top), nil
+}
+
+func (a *analyzer) readPcap(path string) error {
+	r, err := pcap.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		a.add(p.Time, p.Src, p.Dst, p.Payload)
+	}
+}
+
+// readDnstap uses the response of messages logging both sides of an
+// exchange, so queries are not counted twice.
+func (a *analyzer) readDnstap(path string) error {
+	r, err := dnstap.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case m.ResponseMessage != nil:
+			a.add(m.ResponseTime, m.ResponseAddr, m.QueryAddr, m.ResponseMessage)
+		case m.QueryMessage != nil:
+			a.add(m.QueryTime, m.QueryAddr, m.ResponseAddr, m.QueryMessage)
+		}
+	}
+}
+
+func (a *analyzer) add(ts time.Time, src, dst netip.AddrPort, payload []byte) {
+	a.report.Messages++
+	if a.report.Start.IsZero() || ts.Before(a.report.Start) {
+		a.report.Start = ts
+	}
+	if ts.After(a.report.End) {
+		a.report.End = ts
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(payload); err != nil || len(m.Question) == 0 {
+		a.report.Malformed++
+		return
+	}
+	q := m.Question[0]
+	qtype := dns.TypeToString[q.Qtype]
+
+	if !m.Response {
+		a.report.Queries++
+		a.names[strings.ToLower(q.Name)]++
+		a.typeCount(qtype).Queries++
+		t := &Transaction{
+			Time:   ts,
+			Client: src.String(),
+			Server: dst.String(),
+			ID:     m.Id,
+			Name:   q.Name,
+			Type:   qtype,
+		}
+		key := transactionKey{src, dst, m.Id}
+		a.pending[key] = append(a.pending[key], t)
+		a.queries = append(a.queries, t)
+		return
+	}
+
+	a.report.Responses++
+	rcode := dns.RcodeToString[m.Rcode]
+	a.rcodes[rcode]++
+	var answers []ParsedRecord
+	for _, rr := range m.Answer {
+		recordType := dns.TypeToString[rr.Header().Rrtype]
+		a.typeCount(recordType).Answers++
+		answers = append(answers, ParseRecord(rr, recordType))
+	}
+	key := transactionKey{dst, src, m.Id}
+	pending := a.pending[key]
+	if len(pending) == 0 {
+		a.report.UnmatchedResponses++
+		return
+	}
+	t := pending[0]
+	if len(pending) == 1 {
+		delete(a.pending, key)
+	} else {
+		a.pending[key] = pending[1:]
+	}
+	t.Rcode = rcode
+	t.Duration = ts.Sub(t.Time)
+	t.Answers = answers
+	a.answered = append(a.answered, t)
+}
+
+func (a *analyzer) typeCount(recordType string) *TypeCount {
+	tc, ok := a.types[recordType]
+	if !ok {
+		tc = &TypeCount{Type: recordType}
+		a.types[recordType] = tc
+	}
+	return tc
+}
+
+func (a *analyzer) finish(top int) *AnalyzeReport {
+	report := &a.report
+	report.Answered = len(a.answered)
+	report.Unanswered = report.Queries - report.Answered
+	report.TopNames = rankCounts(a.names, top)
+	report.Rcodes = rankCounts(a.rcodes, 0)
+	for _, tc := range a.types {
+		report.RecordTypes = append(report.RecordTypes, *tc)
+	}
+	sort.Slice(report.RecordTypes, func(i, j int) bool {
+		ti, tj := report.RecordTypes[i], report.RecordTypes[j]
+		if ti.Queries+ti.Answers != tj.Queries+tj.Answers {
+			return ti.Queries+ti.Answers > tj.Queries+tj.Answers
+		}
+		return ti.Type < tj.Type
+	})
+
+	sort.SliceStable(a.answered, func(i, j int) bool {
+		return a.answered[i].Duration > a.answered[j].Duration
+	})
+	for i, t := range a.answered {
+		if top > 0 && i == top {
+			break
+		}
+		report.Slowest = append(report.Slowest, *t)
+	}
+	for _, t := range a.queries {
+		if top > 0 && len(report.UnansweredQueries) == top {
+			break
+		}
+		if t.Rcode == "" {
+			report.UnansweredQueries = append(report.UnansweredQueries, *t)
+		}
+	}
+	return report
+}
+
+// rankCounts sorts counts by count, then name, keeping at most top entries
+// when top is positive.
+func rankCounts(counts map[string]int, top int) []NameCount {
+	ranked := make([]NameCount, 0, len(counts))
+	for name, count := range counts {
+		ranked = append(ranked, NameCount{Name: name, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if top > 0 && len(ranked) > top {
+		ranked = ranked[:top]
+	}
+	return ranked
+}
+
+func printAnalyzeReport(report *AnalyzeReport) {
+	fmt.Printf("\n📦 Analysis of %s (%s):\n", report.File, report.Format)
+	if report.Messages == 0 {
+		fmt.Println("❌ No DNS messages found")
+		return
+	}
+	fmt.Printf("🕐 %s → %s (%s)\n", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339),
+		report.End.Sub(report.Start).Round(time.Millisecond))
+	fmt.Printf("  Messages: %d (%d malformed)\n", report.Messages, report.Malformed)
+	fmt.Printf("  Queries: %d | Responses: %d | Answered: %d | Unanswered: %d | Unmatched responses: %d\n",
+		report.Queries, report.Responses, report.Answered, report.Unanswered, report.UnmatchedResponses)
+
+	if len(report.TopNames) > 0 {
+		fmt.Printf("\n🔝 Top queried names:\n")
+		for _, nc := range report.TopNames {
+			fmt.Printf("  %6d  %s\n", nc.Count, displayDomain(nc.Name))
+		}
+	}
+	if len(report.Rcodes) > 0 {
+		fmt.Printf("\n📊 Response codes:\n")
+		for _, nc := range report.Rcodes {
+			fmt.Printf("  %6d  %s (%.1f%%)\n", nc.Count, nc.Name, float64(nc.Count)/float64(report.Responses)*100)
+		}
+	}
+	if len(report.RecordTypes) > 0 {
+		fmt.Printf("\n🔍 Record types:\n")
+		fmt.Printf("  %-10s %8s %8s\n", "TYPE", "QUERIES", "ANSWERS")
+		for _, tc := range report.RecordTypes {
+			fmt.Printf("  %-10s %8d %8d\n", tc.Type, tc.Queries, tc.Answers)
+		}
+	}
+	if len(report.Slowest) > 0 {
+		fmt.Printf("\n🐢 Slowest transactions:\n")
+		for _, t := range report.Slowest {
+			fmt.Printf("  %10s  %s %s %s (%s → %s)\n", t.Duration.Round(time.Microsecond), displayDomain(t.Name), t.Type, t.Rcode, t.Client, t.Server)
+			for _, record := range t.Answers {
+				fmt.Printf("              %s ", record.Type)
+				printRecord(record)
+			}
+		}
+	}
+	if len(report.UnansweredQueries) > 0 {
+		fmt.Printf("\n❓ Unanswered queries:\n")
+		for _, t := range report.UnansweredQueries {
+			fmt.Printf("  %s  %s %s (%s → %s, id %d)\n", t.Time.Format(time.RFC3339), displayDomain(t.Name), t.Type, t.Client, t.Server, t.ID)
+		}
+	}
+}
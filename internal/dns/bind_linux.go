@@ -0,0 +1,31 @@
+//go:build linux
+
+package dns
+
+import (
+	"errors"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"cDNS/internal/logger"
+)
+
+// bindToDevice pins sockets to iface with SO_BINDTODEVICE. Without
+// CAP_NET_RAW the kernel refuses, and the socket stays bound to the
+// interface's address only.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		if errors.Is(sockErr, syscall.EPERM) {
+			logger.GetLogger().Debug("SO_BINDTODEVICE not permitted, binding by address only", zap.String("interface", iface))
+			return nil
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package dns
+
+import "syscall"
+
+// bindToDevice is a no-op where interface pinning is unavailable; sockets are
+// bound to the interface's address instead.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"cDNS/internal/dnstap"
+	"cDNS/internal/logger"
+	"cDNS/internal/pcap"
+)
+
+// Recorder receives the wire format of the messages exchanged with
+// nameservers, e.g. to write them to a packet capture. local and remote are
+// the addresses of this end and of the nameserver.
+type Recorder interface {
+	Query(protocol dnstap.Protocol, local, remote netip.AddrPort, ts time.Time, msg []byte)
+	Response(protocol dnstap.Protocol, local, remote netip.AddrPort, queryTime, ts time.Time, msg []byte)
+}
+
+// PcapRecorder records exchanges to a packet capture. dnstap.Writer is a
+// Recorder as it is.
+func PcapRecorder(w *pcap.Writer) Recorder {
+	return pcapRecorder{w}
+}
+
+type pcapRecorder struct {
+	w *pcap.Writer
+}
+
+func (r pcapRecorder) Query(_ dnstap.Protocol, local, remote netip.AddrPort, ts time.Time, msg []byte) {
+	r.write(ts, local, remote, msg)
+}
+
+func (r pcapRecorder) Response(_ dnstap.Protocol, local, remote netip.AddrPort, _, ts time.Time, msg []byte) {
+	r.write(ts, remote, local, msg)
+}
+
+func (r pcapRecorder) write(ts time.Time, src, dst netip.AddrPort, payload []byte) {
+	if err := r.w.WritePacket(ts, src, dst, payload); err != nil {
+		logger.GetLogger().Warn("Failed to write packet capture", zap.Error(err))
+	}
+}
+
+// Recorders combines recorders into one, skipping nil ones. It returns nil
+// when none is left.
+func Recorders(recs ...Recorder) Recorder {
+	var all multiRecorder
+	for _, rec := range recs {
+		if rec != nil {
+			all = append(all, rec)
+		}
+	}
+	switch len(all) {
+	case 0:
+		return nil
+	case 1:
+		return all[0]
+	}
+	return all
+}
+
+type multiRecorder []Recorder
+
+func (m multiRecorder) Query(protocol dnstap.Protocol, local, remote netip.AddrPort, ts time.Time, msg []byte) {
+	for _, rec := range m {
+		rec.Query(protocol, local, remote, ts, msg)
+	}
+}
+
+func (m multiRecorder) Response(protocol dnstap.Protocol, local, remote netip.AddrPort, queryTime, ts time.Time, msg []byte) {
+	for _, rec := range m {
+		rec.Response(protocol, local, remote, queryTime, ts, msg)
+	}
+}
+
+// exchangeRecorded does what dns.Client.Exchange does on a connection of its
+// own, so the exact bytes sent and received can be recorded.
+func exchangeRecorded(c *dns.Client, m *dns.Msg, address string, rec Recorder) (*dns.Msg, time.Duration, error) {
+	co, err := c.Dial(address)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer co.Close()
+	if opt := m.IsEdns0(); opt != nil && opt.UDPSize() >= dns.MinMsgSize {
+		co.UDPSize = opt.UDPSize()
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	local, remote := addrPort(co.LocalAddr()), addrPort(co.RemoteAddr())
+	_, datagram := co.Conn.(net.PacketConn)
+	protocol := dnstap.UDP
+	if strings.HasSuffix(c.Net, "-tls") {
+		protocol = dnstap.DOT
+	} else if !datagram {
+		protocol = dnstap.TCP
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	start := time.Now()
+	co.SetDeadline(start.Add(timeout))
+	if _, err := co.Write(packed); err != nil {
+		return nil, 0, err
+	}
+	rec.Query(protocol, local, remote, start, packed)
+	for {
+		raw, err := co.ReadMsgHeader(nil)
+		rtt := time.Since(start)
+		if err != nil {
+			return nil, rtt, err
+		}
+		rec.Response(protocol, local, remote, start, start.Add(rtt), raw)
+		r := new(dns.Msg)
+		if err := r.Unpack(raw); err != nil {
+			return nil, rtt, err
+		}
+		// Like dns.Client, skip datagrams answering earlier queries.
+		if r.Id == m.Id {
+			return r, rtt, nil
+		}
+		if !datagram {
+			return nil, rtt, dns.ErrId
+		}
+	}
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return ap
+}
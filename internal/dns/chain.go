@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"cDNS/internal/config"
+)
+
+// MaxChainLength is the number of CNAME/DNAME hops followed before a chain is
+// reported as too long.
+const MaxChainLength = 16
+
+type ChainHop struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	TTL    uint32 `json:"ttl"`
+}
+
+// Chain is the ordered CNAME/DNAME chain from the queried name to the name
+// holding the final address records.
+type Chain struct {
+	Hops      []ChainHop     `json:"hops"`
+	Final     string         `json:"final"`
+	Addresses []ParsedRecord `json:"addresses,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// FollowChain resolves the full alias chain of domain at nameserver, querying
+// again whenever the server stops at a name it did not resolve itself, e.g.
+// when it is authoritative only for part of the chain.
+func FollowChain(domain, nameserver string, cfg config.Config, rec Recorder) *Chain {
+	chain := &Chain{Final: dns.Fqdn(domain)}
+	seen := map[string]bool{dns.CanonicalName(domain): true}
+	for {
+		m := new(dns.Msg)
+		m.SetQuestion(chain.Final, dns.TypeA)
+		m.RecursionDesired = true
+		r, _, err := Exchange(m, nameserver, cfg, rec)
+		if err != nil {
+			chain.Error = fmt.Sprintf("querying %s: %v", chain.Final, err)
+			return chain
+		}
+		if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			chain.Error = fmt.Sprintf("querying %s: %s", chain.Final, dns.RcodeToString[r.Rcode])
+			return chain
+		}
+		moved := false
+		for {
+			hop, ok := nextHop(r.Answer, chain.Final)
+			if !ok {
+				break
+			}
+			chain.Hops = append(chain.Hops, hop)
+			chain.Final = hop.Target
+			moved = true
+			if seen[dns.CanonicalName(hop.Target)] {
+				chain.Error = fmt.Sprintf("alias loop detected at %s", hop.Target)
+				return chain
+			}
+			seen[dns.CanonicalName(hop.Target)] = true
+			if len(chain.Hops) > MaxChainLength {
+				chain.Error = fmt.Sprintf("chain exceeds %d hops", MaxChainLength)
+				return chain
+			}
+		}
+		addresses := ownedBy(r.Answer, chain.Final, dns.TypeA)
+		if len(addresses) > 0 || !moved {
+			if r.Rcode == dns.RcodeNameError {
+				chain.Error = fmt.Sprintf("%s does not exist", chain.Final)
+				return chain
+			}
+			for _, rr := range addresses {
+				chain.Addresses = append(chain.Addresses, ParseRecord(rr, "A"))
+			}
+			break
+		}
+	}
+
+	aaaa, err := QueryDNS(chain.Final, nameserver, dns.TypeAAAA, cfg, rec)
+	if err != nil {
+		chain.Error = fmt.Sprintf("querying %s AAAA: %v", chain.Final, err)
+		return chain
+	}
+	for _, rr := range ownedBy(aaaa, chain.Final, dns.TypeAAAA) {
+		chain.Addresses = append(chain.Addresses, ParseRecord(rr, "AAAA"))
+	}
+	return chain
+}
+
+// nextHop finds the alias that redirects name within answer. A DNAME whose
+// owner is an ancestor of name rewrites its suffix (RFC 6672).
+func nextHop(answer []dns.RR, name string) (ChainHop, bool) {
+	for _, rr := range answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			if strings.EqualFold(rr.Hdr.Name, name) {
+				return ChainHop{Name: name, Type: "CNAME", Target: rr.Target, TTL: rr.Hdr.Ttl}, true
+			}
+		case *dns.DNAME:
+			if !strings.EqualFold(rr.Hdr.Name, name) && dns.IsSubDomain(rr.Hdr.Name, name) {
+				prefix := name[:len(name)-len(rr.Hdr.Name)]
+				return ChainHop{Name: name, Type: "DNAME", Target: prefix + rr.Target, TTL: rr.Hdr.Ttl}, true
+			}
+		}
+	}
+	return ChainHop{}, false
+}
+
+func ownedBy(rrs []dns.RR, name string, rrtype uint16) []dns.RR {
+	var owned []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype && strings.EqualFold(rr.Header().Name, name) {
+			owned = append(owned, rr)
+		}
+	}
+	return owned
+}
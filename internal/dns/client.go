@@ -1,33 +1,104 @@
 package dns
 
 import (
+	"cDNS/internal/catalog"
 	"cDNS/internal/logger"
+	"context"
+	"fmt"
 	"go.uber.org/zap"
 	"net"
+	"net/netip"
 	"strings"
 )
 
-func PrepareNameservers(nameservers []string) []string {
+// PrepareNameservers validates nameserver arguments, adding the default port
+// where missing. IPv6 addresses may be given bare, bracketed with a port, or
+// with a zone ID for link-local servers. "@id" arguments expand to the
+// addresses of a catalog resolver, of both families unless one is requested,
+// so IPv6-only entries work without -6. A non-zero family (4 or 6) drops
+// addresses of the other family and requires hostnames to resolve in it.
+func PrepareNameservers(nameservers []string, family int) []string {
 	var prepared []string
 	for _, ns := range nameservers {
 		if strings.HasPrefix(ns, "-") {
 			continue
 		}
-		if !strings.Contains(ns, ":") {
-			ns += ":53"
+		if strings.HasPrefix(ns, "@") {
+			group, ok := catalog.Get().Find(ns[1:])
+			if !ok {
+				logger.GetLogger().Warn("Unknown nameserver group", zap.String("group", ns))
+				continue
+			}
+			var addresses []string
+			for _, address := range group.Addresses() {
+				if addr, err := netip.ParseAddr(address); err != nil || familyMatches(addr, family) {
+					addresses = append(addresses, address)
+				}
+			}
+			prepared = append(prepared, PrepareNameservers(addresses, family)...)
+			continue
 		}
-		host, port, err := net.SplitHostPort(ns)
+		if strings.Contains(ns, "://") {
+			if _, err := ParseEndpoint(ns); err != nil {
+				logger.GetLogger().Warn("Invalid nameserver format", zap.String("nameserver", ns), zap.Error(err))
+				continue
+			}
+			prepared = append(prepared, ns)
+			continue
+		}
+		host, port, err := net.SplitHostPort(hostPortDefault(ns, "53"))
 		if err != nil {
 			logger.GetLogger().Warn("Invalid nameserver format", zap.String("nameserver", ns))
 			continue
 		}
-		if net.ParseIP(host) == nil {
-			if _, err := net.LookupHost(host); err != nil {
-				logger.GetLogger().Warn("Cannot resolve nameserver", zap.String("host", host))
+		if addr, err := netip.ParseAddr(host); err == nil {
+			if !familyMatches(addr, family) {
+				logger.GetLogger().Warn("Nameserver does not match the requested address family", zap.String("nameserver", ns), zap.Int("family", family))
 				continue
 			}
+		} else if err := resolveHost(host, family); err != nil {
+			logger.GetLogger().Warn("Cannot resolve nameserver", zap.String("host", host), zap.Error(err))
+			continue
 		}
-		prepared = append(prepared, host+":"+port)
+		prepared = append(prepared, net.JoinHostPort(host, port))
 	}
 	return prepared
 }
+
+func familyMatches(addr netip.Addr, family int) bool {
+	switch family {
+	case 4:
+		return addr.Unmap().Is4()
+	case 6:
+		return addr.Is6() && !addr.Is4In6()
+	}
+	return true
+}
+
+// resolveHost checks that host has at least one address of the given family.
+func resolveHost(host string, family int) error {
+	network := "ip"
+	if family == 4 || family == 6 {
+		network = fmt.Sprintf("ip%d", family)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(context.Background(), network, host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no %s addresses", network)
+	}
+	return nil
+}
+
+// displayName returns the nameserver as shown in results: the host without
+// port for plain nameservers, the full endpoint otherwise.
+func displayName(nameserver string) string {
+	if strings.Contains(nameserver, "://") {
+		return nameserver
+	}
+	if host, _, err := net.SplitHostPort(nameserver); err == nil {
+		return host
+	}
+	return nameserver
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"cDNS/internal/config"
+)
+
+// ParseSource parses a source address given as "ip" or "ip:port"; IPv6
+// addresses with a port must be bracketed.
+func ParseSource(source string) (netip.AddrPort, error) {
+	host, port, err := net.SplitHostPort(hostPortDefault(source, "0"))
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid source address %q", source)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid source address %q", source)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid source port %q", port)
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+// ValidateBinding checks the source address and interface of cfg before any
+// query is sent.
+func ValidateBinding(cfg config.Config) error {
+	if cfg.SourceAddress != "" {
+		source, err := ParseSource(cfg.SourceAddress)
+		if err != nil {
+			return err
+		}
+		if !familyMatches(source.Addr(), cfg.IPVersion) {
+			return fmt.Errorf("source address %s does not match IPv%d", source.Addr(), cfg.IPVersion)
+		}
+	}
+	if cfg.Interface != "" {
+		if _, err := net.InterfaceByName(cfg.Interface); err != nil {
+			return fmt.Errorf("interface %q: %v", cfg.Interface, err)
+		}
+	}
+	return nil
+}
+
+// dialer returns the net.Dialer used by every transport to reach address. It
+// binds outgoing sockets to cfg.SourceAddress, or to an address of
+// cfg.Interface matching the nameserver's family, and to the interface itself
+// where the platform allows it.
+func dialer(cfg config.Config, network, address string) (*net.Dialer, error) {
+	d := &net.Dialer{Timeout: cfg.Timeout}
+	if cfg.SourceAddress == "" && cfg.Interface == "" {
+		return d, nil
+	}
+	family := cfg.IPVersion
+	if family == 0 {
+		family = addressFamily(address)
+	}
+
+	var source netip.AddrPort
+	if cfg.SourceAddress != "" {
+		var err error
+		if source, err = ParseSource(cfg.SourceAddress); err != nil {
+			return nil, err
+		}
+	} else {
+		addr, err := interfaceAddr(cfg.Interface, family)
+		if err != nil {
+			return nil, err
+		}
+		source = netip.AddrPortFrom(addr, 0)
+	}
+	if cfg.Interface != "" {
+		d.Control = bindToDevice(cfg.Interface)
+	}
+	if strings.HasPrefix(network, "udp") {
+		d.LocalAddr = net.UDPAddrFromAddrPort(source)
+	} else {
+		d.LocalAddr = net.TCPAddrFromAddrPort(source)
+	}
+	return d, nil
+}
+
+// addressFamily returns 4 or 6 for literal IP addresses and 0 for hostnames.
+func addressFamily(address string) int {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	addr, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		return 0
+	case addr.Unmap().Is4():
+		return 4
+	default:
+		return 6
+	}
+}
+
+// interfaceAddr picks the address of the named interface to send from,
+// preferring global unicast addresses and IPv4 when family is unknown.
+func interfaceAddr(name string, family int) (netip.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("interface %q: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("interface %q: %v", name, err)
+	}
+	var fallback netip.Addr
+	for _, want := range []int{family, 4, 6} {
+		if want == 0 || (family != 0 && want != family) {
+			continue
+		}
+		for _, a := range addrs {
+			prefix, err := netip.ParsePrefix(a.String())
+			if err != nil || !familyMatches(prefix.Addr(), want) {
+				continue
+			}
+			addr := prefix.Addr().Unmap()
+			if addr.IsGlobalUnicast() || addr.IsLoopback() {
+				return addr, nil
+			}
+			if addr.IsLinkLocalUnicast() && !fallback.IsValid() {
+				fallback = addr.WithZone(iface.Name)
+			}
+		}
+	}
+	if fallback.IsValid() {
+		return fallback, nil
+	}
+	return netip.Addr{}, fmt.Errorf("interface %q has no usable address", name)
+}
@@ -0,0 +1,236 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	"cDNS/internal/logger"
+)
+
+// EnumRecordTypes are queried for each candidate unless --filter is given.
+var EnumRecordTypes = []string{"A", "AAAA", "CNAME"}
+
+// wildcardProbes is the number of random labels queried to detect wildcards.
+const wildcardProbes = 3
+
+// Wildcard holds the records a zone returns for names that do not exist.
+type Wildcard struct {
+	Probes  []string        `json:"probes"`
+	Records map[string]bool `json:"-"`
+}
+
+func (w *Wildcard) Detected() bool {
+	return len(w.Records) > 0
+}
+
+// Matches reports whether every record of result is a wildcard answer.
+func (w *Wildcard) Matches(result Result) bool {
+	if !w.Detected() {
+		return false
+	}
+	for _, records := range result.Records {
+		for _, record := range records {
+			if !w.Records[recordKey(record)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func Enum(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	domain, err := ToASCII(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	domain = dns.Fqdn(domain)
+	if err := ValidateDomain(domain, ModeDNSName); err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	wordlist, _ := cmd.Flags().GetString("wordlist")
+	words, err := ReadNames(wordlist)
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to read wordlist", zap.Error(err))
+	}
+	candidates := EnumCandidates(domain, words)
+	if len(candidates) == 0 {
+		logger.GetLogger().Fatal("Wordlist contains no valid labels", zap.String("wordlist", wordlist))
+	}
+
+	nameservers := args[1:]
+	if len(nameservers) == 0 {
+		rc, err := LoadResolvConf(DefaultResolvConf)
+		if err != nil {
+			logger.GetLogger().Fatal("No nameservers provided and system resolver configuration unavailable", zap.Error(err))
+		}
+		nameservers = rc.Nameservers
+	}
+	if err := ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	nameservers = PrepareNameservers(nameservers, cfg.IPVersion)
+	if len(nameservers) == 0 {
+		logger.GetLogger().Fatal("No valid nameservers provided")
+	}
+	if len(cfg.RecordFilter) == 0 {
+		cfg.RecordFilter = EnumRecordTypes
+	}
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+	var out io.Writer = os.Stdout
+	if cfg.OutputFile != "" {
+		f, err := os.Create(cfg.OutputFile)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to create output file", zap.Error(err))
+		}
+		defer f.Close()
+		out = f
+	}
+
+	wildcard := DetectWildcard(domain, nameservers, cfg)
+	if wildcard.Detected() {
+		fmt.Fprintf(os.Stderr, "⚠️  Wildcard records detected under %s, matching answers are filtered\n", domain)
+	}
+	logger.GetLogger().Info("Starting enumeration", zap.String("domain", domain), zap.Int("candidates", len(candidates)), zap.Strings("nameservers", nameservers))
+	encoder := json.NewEncoder(out)
+	found := Enumerate(candidates, nameservers, cfg, concurrency, wildcard, func(result Result) {
+		if cfg.JSONOutput {
+			if err := encoder.Encode(result); err != nil {
+				logger.GetLogger().Error("Failed to write result", zap.Error(err))
+			}
+			return
+		}
+		fmt.Fprintln(out, enumLine(result))
+	})
+	fmt.Fprintf(os.Stderr, "📋 Found %d of %d names\n", found, len(candidates))
+}
+
+// EnumCandidates prefixes domain with each word, dropping duplicates and
+// words that do not form a valid name.
+func EnumCandidates(domain string, words []string) []string {
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, word := range words {
+		label, err := ToASCII(strings.Trim(strings.ToLower(word), "."))
+		if err != nil || label == "" {
+			continue
+		}
+		name := label + "." + domain
+		if seen[name] || ValidateDomain(name, ModeDNSName) != nil {
+			continue
+		}
+		seen[name] = true
+		candidates = append(candidates, name)
+	}
+	return candidates
+}
+
+// DetectWildcard queries random labels under domain on every nameserver and
+// records the answers, which a wildcard returns for any name.
+func DetectWildcard(domain string, nameservers []string, cfg config.Config) *Wildcard {
+	w := &Wildcard{Records: make(map[string]bool)}
+	for i := 0; i < wildcardProbes; i++ {
+		probe := RandomLabel() + "." + domain
+		w.Probes = append(w.Probes, probe)
+		for _, ns := range nameservers {
+			for _, records := range Nameserver(probe, ns, cfg, nil).Records {
+				for _, record := range records {
+					w.Records[recordKey(record)] = true
+				}
+			}
+		}
+	}
+	return w
+}
+
+// Enumerate queries candidates with up to concurrency workers, spreading them
+// over nameservers, and calls found for every name with non-wildcard records.
+// Calls to found are serialized. It returns the number of names found.
+func Enumerate(candidates, nameservers []string, cfg config.Config, concurrency int, wildcard *Wildcard, found func(Result)) int {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	jobs := make(chan int)
+	results := make(chan Result)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results <- Nameserver(candidates[i], nameservers[i%len(nameservers)], cfg, nil)
+			}
+		}()
+	}
+	go func() {
+		for i := range candidates {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	count := 0
+	for result := range results {
+		if len(result.Records) == 0 || wildcard.Matches(result) {
+			continue
+		}
+		count++
+		found(result)
+	}
+	return count
+}
+
+func enumLine(result Result) string {
+	var types []string
+	for recordType := range result.Records {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	var parts []string
+	for _, recordType := range types {
+		for _, record := range result.Records[recordType] {
+			value := record.Address
+			if value == "" {
+				value = record.Host
+			}
+			parts = append(parts, fmt.Sprintf("%s %s", recordType, displayDomain(value)))
+		}
+	}
+	return fmt.Sprintf("%s\t%s", displayDomain(result.Domain), strings.Join(parts, ", "))
+}
+
+// recordKey identifies a record by its data, ignoring the TTL.
+func recordKey(record ParsedRecord) string {
+	record.TTL = 0
+	return fmt.Sprintf("%+v", record)
+}
+
+// RandomLabel returns a label that is practically certain not to exist.
+func RandomLabel() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "cdns-" + hex.EncodeToString(b)
+}
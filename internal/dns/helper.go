@@ -11,23 +11,6 @@ import (
 	"time"
 )
 
-func IsValidDomain(domain string) bool {
-	if len(domain) == 0 || len(domain) > 253 {
-		return false
-	}
-	domain = strings.TrimSuffix(domain, ".")
-	parts := strings.Split(domain, ".")
-	if len(parts) < 2 {
-		return false
-	}
-	for _, part := range parts {
-		if len(part) == 0 || len(part) > 63 {
-			return false
-		}
-	}
-	return true
-}
-
 func JsonOutput(results []Result, cfg config.Config) {
 	jsonOutput, err := json.MarshalIndent(results, "", "  ")
 	if err != nil {
@@ -45,8 +28,14 @@ func JsonOutput(results []Result, cfg config.Config) {
 }
 
 func printHumanReadableResult(result Result, cfg config.Config) {
-	fmt.Printf("\n📊 Results for %s via %s:\n", result.Domain, result.Nameserver)
+	fmt.Printf("\n📊 Results for %s via %s:\n", displayDomain(result.Domain), result.Nameserver)
 	fmt.Printf("🕐 Query time: %s\n", result.QueryTime.Format(time.RFC3339))
+	if len(result.Candidates) > 0 {
+		fmt.Printf("🔎 Search: %s → %s (tried %s)\n", result.Query, displayDomain(result.Domain), strings.Join(result.Candidates, ", "))
+	}
+	if result.Chain != nil {
+		printChain(result.Chain)
+	}
 	if len(result.Records) == 0 {
 		fmt.Println("❌ No records found")
 		return
@@ -71,27 +60,50 @@ func printHumanReadableResult(result Result, cfg config.Config) {
 	fmt.Printf("  Average response time: %v\n", result.Statistics.AverageResponseTime)
 }
 
+func printChain(chain *Chain) {
+	fmt.Printf("\n🔗 Alias chain:\n")
+	for i, hop := range chain.Hops {
+		fmt.Printf("  %d. %s --%s (TTL %d)--> %s\n", i+1, displayDomain(hop.Name), hop.Type, hop.TTL, displayDomain(hop.Target))
+	}
+	if chain.Error != "" {
+		fmt.Printf("  ❌ %s\n", chain.Error)
+		return
+	}
+	fmt.Printf("  Final: %s\n", displayDomain(chain.Final))
+	if len(chain.Addresses) == 0 {
+		fmt.Println("  No addresses found")
+	}
+	for _, record := range chain.Addresses {
+		fmt.Printf("    %s ", record.Type)
+		printRecord(record)
+	}
+}
+
 func printRecord(record ParsedRecord) {
 	fmt.Printf("TTL: %d", record.TTL)
 	switch record.Type {
 	case "A", "AAAA":
 		fmt.Printf(" | Address: %s", record.Address)
 	case "CNAME":
-		fmt.Printf(" | Target: %s", record.Address)
+		fmt.Printf(" | Target: %s", displayDomain(record.Address))
 	case "MX":
-		fmt.Printf(" | Host: %s | Priority: %d", record.Host, record.Pref)
+		fmt.Printf(" | Host: %s | Priority: %d", displayDomain(record.Host), record.Pref)
 	case "NS":
-		fmt.Printf(" | Nameserver: %s", record.Host)
+		fmt.Printf(" | Nameserver: %s", displayDomain(record.Host))
 	case "TXT":
 		fmt.Printf(" | Text: %s", record.Text)
 	case "PTR":
-		fmt.Printf(" | Pointer: %s", record.Host)
+		fmt.Printf(" | Pointer: %s", displayDomain(record.Host))
 	case "SRV":
-		fmt.Printf(" | Target: %s | Port: %d | Priority: %d | Weight: %d", record.Target, record.Port, record.Priority, record.Weight)
+		fmt.Printf(" | Target: %s | Port: %d | Priority: %d | Weight: %d", displayDomain(record.Target), record.Port, record.Priority, record.Weight)
 	case "SOA":
-		fmt.Printf(" | Master: %s | Email: %s | Serial: %d", record.MName, record.RName, record.Serial)
+		fmt.Printf(" | Master: %s | Email: %s | Serial: %d", displayDomain(record.MName), record.RName, record.Serial)
+	case "NSEC":
+		fmt.Printf(" | Next: %s | Types: %s", displayDomain(record.Target), strings.Join(record.Types, " "))
+	case "NSEC3PARAM":
+		fmt.Printf(" | Iterations: %d | Salt: %s", record.Iterations, record.Salt)
 	case "CAA":
-		fmt.Printf(" | Tag: %d | Value: %s", record.Tag, record.Value)
+		fmt.Printf(" | Flag: %d | Tag: %s | Value: %s", record.Flag, record.Tag, record.Value)
 	default:
 		if record.RawData != "" {
 			fmt.Printf(" | Data: %s", record.RawData)
@@ -0,0 +1,254 @@
+package dns
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	"cDNS/internal/logger"
+)
+
+// ChaosNames are the CHAOS class TXT names servers answer with their software
+// version and the name of the instance, which tells anycast sites apart.
+var ChaosNames = []string{"version.bind", "hostname.bind", "id.server", "version.server"}
+
+// Identity is what a nameserver reveals about the software and instance that
+// answered.
+type Identity struct {
+	Nameserver   string            `json:"nameserver"`
+	Resolver     string            `json:"resolver,omitempty"`
+	Reachable    bool              `json:"reachable"`
+	NSID         string            `json:"nsid,omitempty"`
+	Chaos        map[string]string `json:"chaos,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+	ResponseTime time.Duration     `json:"response_time"`
+}
+
+// ResolverHealth is a catalog resolver with the identity of each of its plain
+// DNS addresses.
+type ResolverHealth struct {
+	catalog.Resolver
+	Health []Identity `json:"health"`
+}
+
+func Identify(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	nameservers := args
+	if len(nameservers) == 0 {
+		rc, err := LoadResolvConf(DefaultResolvConf)
+		if err != nil {
+			logger.GetLogger().Fatal("No nameservers provided and system resolver configuration unavailable", zap.Error(err))
+		}
+		nameservers = rc.Nameservers
+	}
+	if err := ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	nameservers = PrepareNameservers(nameservers, cfg.IPVersion)
+	if len(nameservers) == 0 {
+		logger.GetLogger().Fatal("No valid nameservers provided")
+	}
+
+	logger.GetLogger().Info("Identifying nameservers", zap.Strings("nameservers", nameservers))
+	identities := IdentifyAll(nameservers, cfg, nil)
+	if cfg.JSONOutput {
+		out, err := json.MarshalIndent(identities, "", "  ")
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to marshal results", zap.Error(err))
+		}
+		if cfg.OutputFile != "" {
+			if err := os.WriteFile(cfg.OutputFile, out, 0644); err != nil {
+				logger.GetLogger().Fatal("Failed to write output file", zap.Error(err))
+			}
+			fmt.Printf("Results written to: %s\n", cfg.OutputFile)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+	for _, id := range identities {
+		printIdentity(id)
+	}
+}
+
+// IdentifyAll identifies nameservers in parallel, keeping their order.
+// Every message exchanged is passed to rec unless it is nil.
+func IdentifyAll(nameservers []string, cfg config.Config, rec Recorder) []Identity {
+	identities := make([]Identity, len(nameservers))
+	var wg sync.WaitGroup
+	for i, ns := range nameservers {
+		wg.Add(1)
+		go func(i int, ns string) {
+			defer wg.Done()
+			identities[i] = IdentifyNameserver(ns, cfg, rec)
+		}(i, ns)
+	}
+	wg.Wait()
+	return identities
+}
+
+// CatalogHealth identifies every address of resolvers in parallel.
+func CatalogHealth(resolvers []catalog.Resolver, cfg config.Config, rec Recorder) []ResolverHealth {
+	var nameservers []string
+	counts := make([]int, len(resolvers))
+	for i, r := range resolvers {
+		prepared := PrepareNameservers(r.Addresses(), cfg.IPVersion)
+		counts[i] = len(prepared)
+		nameservers = append(nameservers, prepared...)
+	}
+	identities := IdentifyAll(nameservers, cfg, rec)
+	health := make([]ResolverHealth, len(resolvers))
+	for i, r := range resolvers {
+		health[i] = ResolverHealth{Resolver: r, Health: identities[:counts[i]]}
+		identities = identities[counts[i]:]
+	}
+	return health
+}
+
+// IdentifyNameserver sends a CH TXT query for each of ChaosNames, asking for
+// the NSID option (RFC 5001) at the same time.
+func IdentifyNameserver(nameserver string, cfg config.Config, rec Recorder) Identity {
+	id := Identity{
+		Nameserver: displayName(nameserver),
+		Chaos:      make(map[string]string),
+		Errors:     make(map[string]string),
+	}
+	id.Resolver = catalog.Get().NameFor(id.Nameserver)
+	var total time.Duration
+	responses := 0
+	for _, name := range ChaosNames {
+		r, rtt, err := chaosQuery(name, nameserver, cfg, rec)
+		if err != nil {
+			logger.GetLogger().Debug("CHAOS query failed", zap.String("name", name), zap.String("nameserver", nameserver), zap.Error(err))
+			id.Errors[name] = err.Error()
+			continue
+		}
+		responses++
+		total += rtt
+		if id.NSID == "" {
+			id.NSID = responseNSID(r)
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			id.Errors[name] = dns.RcodeToString[r.Rcode]
+			continue
+		}
+		for _, rr := range r.Answer {
+			if txt, ok := rr.(*dns.TXT); ok {
+				id.Chaos[name] = strings.Join(txt.Txt, "")
+				break
+			}
+		}
+	}
+	id.Reachable = responses > 0
+	if responses > 0 {
+		id.ResponseTime = total / time.Duration(responses)
+	}
+	return id
+}
+
+func chaosQuery(name, nameserver string, cfg config.Config, rec Recorder) (*dns.Msg, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.Question[0].Qclass = dns.ClassCHAOS
+	m.RecursionDesired = false
+	m.SetEdns0(1232, false)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+
+	var err error
+	for attempt := 0; attempt < max(cfg.Retries, 1); attempt++ {
+		var r *dns.Msg
+		var rtt time.Duration
+		if r, rtt, err = Exchange(m, nameserver, cfg, rec); err == nil {
+			return r, rtt, nil
+		}
+	}
+	return nil, 0, err
+}
+
+// responseNSID returns the NSID of r as text when it is printable, in hex
+// otherwise.
+func responseNSID(r *dns.Msg) string {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, o := range opt.Option {
+		nsid, ok := o.(*dns.EDNS0_NSID)
+		if !ok || nsid.Nsid == "" {
+			continue
+		}
+		raw, err := hex.DecodeString(nsid.Nsid)
+		if err != nil {
+			return nsid.Nsid
+		}
+		for _, b := range raw {
+			if b < 0x20 || b > 0x7e {
+				return nsid.Nsid
+			}
+		}
+		return string(raw)
+	}
+	return ""
+}
+
+func printIdentity(id Identity) {
+	fmt.Printf("\n🪪 %s", id.Nameserver)
+	if id.Resolver != "" {
+		fmt.Printf(" (%s)", id.Resolver)
+	}
+	fmt.Println()
+	if !id.Reachable {
+		fmt.Println("❌ No response")
+		return
+	}
+	fmt.Printf("  %-16s %s\n", "NSID", valueOr(id.NSID, "-"))
+	for _, name := range ChaosNames {
+		value, ok := id.Chaos[name]
+		if !ok {
+			value = valueOr(id.Errors[name], "-")
+		}
+		fmt.Printf("  %-16s %s\n", name, value)
+	}
+	fmt.Printf("  %-16s %v\n", "response time", id.ResponseTime)
+}
+
+// Summary describes the identity on one line, as listed by the catalog
+// health view.
+func (id Identity) Summary() string {
+	if !id.Reachable {
+		return fmt.Sprintf("%s ❌ no response", id.Nameserver)
+	}
+	parts := []string{fmt.Sprintf("%s ✅ %v", id.Nameserver, id.ResponseTime.Round(time.Microsecond))}
+	if id.NSID != "" {
+		parts = append(parts, "nsid: "+id.NSID)
+	}
+	for _, name := range ChaosNames {
+		if value, ok := id.Chaos[name]; ok {
+			parts = append(parts, name+": "+value)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts names for lookup following IDNA 2008 with the UTS #46
+// mappings. Underscores and other non-hostname ASCII are left for the domain
+// validator to judge, and hyphen positions are not checked since many real
+// hostnames use "--" in the third and fourth position.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.BidiRule(),
+	idna.CheckJoiners(true),
+	idna.CheckHyphens(false),
+	idna.StrictDomainName(false),
+)
+
+// Script combinations permitted within a single label, following the
+// "highly restrictive" level of UTS #39.
+var allowedScriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// ToASCII converts a user supplied domain to its A-label form for querying.
+// Pure ASCII names are returned unchanged apart from checking that any
+// "xn--" labels decode correctly.
+func ToASCII(domain string) (string, error) {
+	name := strings.TrimSuffix(domain, ".")
+	if name == "" {
+		return domain, nil
+	}
+	if isASCII(name) {
+		for _, label := range strings.Split(name, ".") {
+			if hasACEPrefix(label) {
+				if _, err := idnaProfile.ToUnicode(label); err != nil {
+					return "", fmt.Errorf("invalid punycode label %q: %v", label, idnaError(err))
+				}
+			}
+		}
+		return domain, nil
+	}
+	ascii, err := idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized domain %q: %v", domain, idnaError(err))
+	}
+	unicodeName, err := idnaProfile.ToUnicode(ascii)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized domain %q: %v", domain, idnaError(err))
+	}
+	for _, label := range strings.Split(unicodeName, ".") {
+		if err := checkScripts(label); err != nil {
+			return "", err
+		}
+	}
+	if strings.HasSuffix(domain, ".") {
+		ascii += "."
+	}
+	return ascii, nil
+}
+
+// ToUnicode returns the U-label form of name, or name itself when it has no
+// punycode labels or they cannot be decoded.
+func ToUnicode(name string) string {
+	if !strings.Contains(strings.ToLower(name), "xn--") {
+		return name
+	}
+	unicodeName, err := idna.Display.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return unicodeName
+}
+
+// displayDomain formats name for human output, showing the U-label form
+// next to the A-label when they differ.
+func displayDomain(name string) string {
+	if unicodeName := ToUnicode(name); unicodeName != name {
+		return fmt.Sprintf("%s (%s)", unicodeName, name)
+	}
+	return name
+}
+
+func checkScripts(label string) error {
+	scripts := make(map[string]bool)
+	for _, r := range label {
+		for name, table := range unicode.Scripts {
+			if name == "Common" || name == "Inherited" {
+				continue
+			}
+			if unicode.Is(table, r) {
+				scripts[name] = true
+				break
+			}
+		}
+	}
+	if len(scripts) <= 1 {
+		return nil
+	}
+	for _, allowed := range allowedScriptSets {
+		ok := true
+		for script := range scripts {
+			if !slices.Contains(allowed, script) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return nil
+		}
+	}
+	names := make([]string, 0, len(scripts))
+	for script := range scripts {
+		names = append(names, script)
+	}
+	slices.Sort(names)
+	return fmt.Errorf("label %q mixes scripts (%s)", label, strings.Join(names, ", "))
+}
+
+func idnaError(err error) string {
+	return strings.TrimPrefix(err.Error(), "idna: ")
+}
+
+func hasACEPrefix(label string) bool {
+	return len(label) >= 4 && strings.EqualFold(label[:4], "xn--")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
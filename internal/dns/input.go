@@ -0,0 +1,33 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadNames reads one name per line from path, or from standard input when
+// path is "-". Blank lines and lines starting with '#' are skipped, as is
+// anything after the first field so hosts-style lists can be reused.
+func ReadNames(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, strings.Fields(line)[0])
+	}
+	return names, scanner.Err()
+}
@@ -40,10 +40,31 @@ func ParseRecord(ans dns.RR, recordType string) ParsedRecord {
 		parsed.Expire = rr.Expire
 		parsed.Minimum = rr.Minttl
 	case *dns.CAA:
-		parsed.Tag = rr.Flag
+		parsed.Flag = rr.Flag
+		parsed.Tag = rr.Tag
 		parsed.Value = rr.Value
+	case *dns.NSEC:
+		parsed.Target = rr.NextDomain
+		parsed.Types = TypeNames(rr.TypeBitMap)
+	case *dns.NSEC3:
+		parsed.Target = rr.NextDomain
+		parsed.Types = TypeNames(rr.TypeBitMap)
+		parsed.Iterations = rr.Iterations
+		parsed.Salt = rr.Salt
+	case *dns.NSEC3PARAM:
+		parsed.Iterations = rr.Iterations
+		parsed.Salt = rr.Salt
 	default:
 		parsed.RawData = ans.String()
 	}
 	return parsed
 }
+
+// TypeNames converts an NSEC or NSEC3 type bitmap to mnemonics.
+func TypeNames(bitmap []uint16) []string {
+	names := make([]string, 0, len(bitmap))
+	for _, t := range bitmap {
+		names = append(names, dns.Type(t).String())
+	}
+	return names
+}
@@ -1,8 +1,13 @@
 package dns
 
 import (
+	"cDNS/internal/catalog"
 	"cDNS/internal/config"
+	"cDNS/internal/dnstap"
 	"cDNS/internal/logger"
+	"cDNS/internal/pcap"
+	"cDNS/internal/rules"
+	"errors"
 	"fmt"
 	"github.com/miekg/dns"
 	"github.com/spf13/cobra"
@@ -15,6 +20,9 @@ import (
 func Query(cmd *cobra.Command, args []string) {
 	cfg := config.GetConfigFromFlags(cmd)
 	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
 
 	if len(args) < 1 {
 		logger.GetLogger().Error("Domain is required")
@@ -25,21 +33,87 @@ func Query(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	domain := dns.Fqdn(args[0])
+	name, err := ToASCII(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
 	nameservers := args[1:]
 
-	if !IsValidDomain(domain) {
-		logger.GetLogger().Fatal("Invalid domain")
+	nameMode, _ := cmd.Flags().GetString("name-mode")
+	mode, err := ParseValidationMode(nameMode)
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid name mode", zap.Error(err))
+	}
+	if err := ValidateDomain(name, mode); err != nil {
+		logger.GetLogger().Fatal("Invalid domain", zap.Error(err))
+	}
+	if len(nameservers) == 0 {
+		if rulesFile, _ := cmd.Flags().GetString("rules"); rulesFile != "" {
+			ruleSet, err := rules.Load(rulesFile)
+			if err != nil {
+				logger.GetLogger().Fatal("Failed to load rules", zap.Error(err))
+			}
+			nameservers = ruleSet.Nameservers(dns.Fqdn(name))
+		}
+	}
+	cfg.Ndots, _ = cmd.Flags().GetInt("ndots")
+	if len(nameservers) == 0 {
+		resolvConf, _ := cmd.Flags().GetString("resolv-conf")
+		rc, err := LoadResolvConf(resolvConf)
+		if err != nil {
+			logger.GetLogger().Fatal("No nameservers provided and system resolver configuration unavailable", zap.Error(err))
+		}
+		nameservers = rc.Nameservers
+		// The search list comes with the system nameservers; names sent to
+		// explicit nameservers are only expanded with --search.
+		cfg.Search = rc.Search
+		if !cmd.Flags().Changed("ndots") {
+			cfg.Ndots = rc.Ndots
+		}
+		if !cmd.Flags().Changed("timeout") {
+			cfg.Timeout = rc.Timeout
+		}
+		if !cmd.Flags().Changed("retries") {
+			cfg.Retries = rc.Attempts
+		}
+		logger.GetLogger().Debug("Using system nameservers", zap.String("resolv_conf", resolvConf), zap.Strings("nameservers", nameservers))
+	}
+	if cmd.Flags().Changed("search") {
+		search, _ := cmd.Flags().GetStringSlice("search")
+		if cfg.Search, err = SearchList(search, mode); err != nil {
+			logger.GetLogger().Fatal("Invalid search domain", zap.Error(err))
+		}
 	}
-	nameservers = PrepareNameservers(nameservers)
+	cfg.Follow, _ = cmd.Flags().GetBool("follow")
+	if err := ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	nameservers = PrepareNameservers(nameservers, cfg.IPVersion)
 	if len(nameservers) == 0 {
 		logger.GetLogger().Fatal("No valid nameservers provided")
 	}
+	var rec Recorder
+	if path, _ := cmd.Flags().GetString("pcap"); path != "" {
+		w, err := pcap.Create(path)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to create packet capture", zap.Error(err))
+		}
+		defer w.Close()
+		rec = PcapRecorder(w)
+	}
+	if spec, _ := cmd.Flags().GetString("dnstap"); spec != "" {
+		w, err := dnstap.Open(spec)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to open dnstap output", zap.Error(err))
+		}
+		defer w.Close()
+		rec = Recorders(rec, w)
+	}
 
-	logger.GetLogger().Info("Starting DNS query", zap.String("domain", domain), zap.Strings("nameservers", nameservers))
+	logger.GetLogger().Info("Starting DNS query", zap.String("domain", name), zap.Strings("nameservers", nameservers))
 	var allResults []Result
 	for _, ns := range nameservers {
-		result := Nameserver(domain, ns, cfg)
+		result := Nameserver(name, ns, cfg, rec)
 		allResults = append(allResults, result)
 		if !cfg.JSONOutput {
 			printHumanReadableResult(result, cfg)
@@ -54,11 +128,19 @@ func Query(cmd *cobra.Command, args []string) {
 	logger.GetLogger().Info("DNS query completed", zap.Int("total_nameservers", len(nameservers)))
 }
 
-func Nameserver(domain, nameserver string, cfg config.Config) Result {
-	originalNS := strings.Split(nameserver, ":")[0]
+// Nameserver queries every record type for name at nameserver. Relative
+// names are expanded with cfg.Search and the first candidate that exists is
+// queried. Every message exchanged is passed to rec unless it is nil.
+func Nameserver(name, nameserver string, cfg config.Config, rec Recorder) Result {
+	candidates := SearchCandidates(name, cfg.Search, cfg.Ndots)
+	domain := candidates[0]
+	if len(candidates) > 1 {
+		domain = resolveSearch(name, candidates, nameserver, cfg, rec)
+	}
 	result := Result{
-		Nameserver: originalNS,
+		Nameserver: displayName(nameserver),
 		Domain:     domain,
+		Unicode:    ToUnicode(domain),
 		QueryTime:  time.Now(),
 		Records:    make(map[string][]ParsedRecord),
 		Errors:     make(map[string]string),
@@ -68,7 +150,7 @@ func Nameserver(domain, nameserver string, cfg config.Config) Result {
 	if len(cfg.RecordFilter) > 0 {
 		recordTypesToQuery = make(map[string]uint16)
 		for _, recordName := range cfg.RecordFilter {
-			if recordType, exists := RecordTypes[strings.ToUpper(recordName)]; exists {
+			if recordType, exists := RecordType(strings.ToUpper(recordName)); exists {
 				recordTypesToQuery[strings.ToUpper(recordName)] = recordType
 			}
 		}
@@ -82,7 +164,7 @@ func Nameserver(domain, nameserver string, cfg config.Config) Result {
 		recordType := recordTypesToQuery[recordName]
 		result.Statistics.TotalQueries++
 		startTime := time.Now()
-		records, err := QueryDNSWithRetry(domain, nameserver, recordType, cfg)
+		records, err := QueryDNSWithRetry(domain, nameserver, recordType, cfg, rec)
 		responseTime := time.Since(startTime)
 		result.Statistics.TotalResponseTime += responseTime
 		if err != nil {
@@ -96,23 +178,51 @@ func Nameserver(domain, nameserver string, cfg config.Config) Result {
 			continue
 		}
 		for _, ans := range records {
-			parsed := ParseRecord(ans, recordName)
+			// Answers may hold the CNAMEs leading to the records asked for;
+			// each keeps its own type.
+			parsed := ParseRecord(ans, dns.TypeToString[ans.Header().Rrtype])
 			result.Records[recordName] = append(result.Records[recordName], parsed)
 		}
 	}
+	if len(candidates) > 1 {
+		result.Query = name
+		result.Candidates = candidates
+	}
+	if cfg.Follow {
+		result.Chain = FollowChain(domain, nameserver, cfg, rec)
+	}
+	if result.Unicode == result.Domain {
+		result.Unicode = ""
+	}
 	if result.Statistics.TotalQueries > 0 {
 		result.Statistics.AverageResponseTime = result.Statistics.TotalResponseTime / time.Duration(result.Statistics.TotalQueries)
 	}
 	return result
 }
 
-func QueryDNSWithRetry(domain, nameserver string, recordType uint16, cfg config.Config) ([]dns.RR, error) {
+// RcodeError is returned by QueryDNS when the server answers with an error
+// response code.
+type RcodeError struct {
+	Rcode int
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("DNS error: %s", dns.RcodeToString[e.Rcode])
+}
+
+// QueryDNSWithRetry retries failed queries, except NXDOMAIN answers, which
+// are definitive.
+func QueryDNSWithRetry(domain, nameserver string, recordType uint16, cfg config.Config, rec Recorder) ([]dns.RR, error) {
 	var lastErr error
 	for attempt := 0; attempt < cfg.Retries; attempt++ {
-		records, err := QueryDNS(domain, nameserver, recordType, cfg.Timeout)
+		records, err := QueryDNS(domain, nameserver, recordType, cfg, rec)
 		if err == nil {
 			return records, nil
 		}
+		var rcodeErr *RcodeError
+		if errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError {
+			return nil, err
+		}
 		lastErr = err
 		if attempt < cfg.Retries-1 {
 			time.Sleep(time.Duration(attempt+1) * time.Second)
@@ -121,9 +231,7 @@ func QueryDNSWithRetry(domain, nameserver string, recordType uint16, cfg config.
 	return nil, fmt.Errorf("failed after %d attempts: %v", cfg.Retries, lastErr)
 }
 
-func QueryDNS(domain, nameserver string, recordType uint16, timeout time.Duration) ([]dns.RR, error) {
-	c := new(dns.Client)
-	c.Timeout = timeout
+func QueryDNS(domain, nameserver string, recordType uint16, cfg config.Config, rec Recorder) ([]dns.RR, error) {
 	m := new(dns.Msg)
 
 	// Ensure domain is fully qualified
@@ -131,17 +239,12 @@ func QueryDNS(domain, nameserver string, recordType uint16, timeout time.Duratio
 	m.SetQuestion(fqdn, recordType)
 	m.RecursionDesired = true
 
-	// Ensure nameserver has port
-	if !strings.Contains(nameserver, ":") {
-		nameserver = nameserver + ":53"
-	}
-
-	r, _, err := c.Exchange(m, nameserver)
+	r, _, err := Exchange(m, nameserver, cfg, rec)
 	if err != nil {
 		return nil, fmt.Errorf("exchange failed: %v", err)
 	}
 	if r.Rcode != dns.RcodeSuccess {
-		return nil, fmt.Errorf("DNS error: %s", dns.RcodeToString[r.Rcode])
+		return nil, &RcodeError{Rcode: r.Rcode}
 	}
 	return r.Answer, nil
 }
@@ -0,0 +1,303 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	"cDNS/internal/logger"
+	"cDNS/internal/pcap"
+)
+
+// Replay outcomes.
+const (
+	ReplayIdentical  = "identical"
+	ReplayDifferent  = "different"
+	ReplayFailed     = "failed"
+	ReplayUnrecorded = "unrecorded"
+)
+
+// ReplayResult compares the recorded response to a captured query with the
+// response the query gets now.
+type ReplayResult struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	CapturedAt time.Time `json:"captured_at"`
+	Status     string    `json:"status"`
+	Recorded   string    `json:"recorded_rcode,omitempty"`
+	Replayed   string    `json:"replayed_rcode,omitempty"`
+	Diff       []string  `json:"diff,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// capturedQuery is a query read from a capture with its recorded response.
+type capturedQuery struct {
+	packet   *pcap.Packet
+	query    *dns.Msg
+	response *dns.Msg
+}
+
+func Replay(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	to, _ := cmd.Flags().GetString("to")
+	if err := ValidateBinding(cfg); err != nil {
+		logger.GetLogger().Fatal("Invalid source binding", zap.Error(err))
+	}
+	nameservers := PrepareNameservers([]string{to}, cfg.IPVersion)
+	if len(nameservers) == 0 {
+		logger.GetLogger().Fatal("Invalid nameserver", zap.String("to", to))
+	}
+	queries, err := ReadCapture(args[0])
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to read packet capture", zap.Error(err))
+	}
+	if len(queries) == 0 {
+		logger.GetLogger().Fatal("No DNS queries found in capture", zap.String("file", args[0]))
+	}
+	var rec Recorder
+	if path, _ := cmd.Flags().GetString("pcap"); path != "" {
+		w, err := pcap.Create(path)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to create packet capture", zap.Error(err))
+		}
+		defer w.Close()
+		rec = PcapRecorder(w)
+	}
+
+	logger.GetLogger().Info("Replaying capture", zap.String("file", args[0]), zap.Int("queries", len(queries)), zap.String("nameserver", nameservers[0]))
+	var results []ReplayResult
+	for _, q := range queries {
+		result := replayQuery(q, nameservers[0], cfg, rec)
+		results = append(results, result)
+		if !cfg.JSONOutput {
+			printReplayResult(result)
+		}
+	}
+	if cfg.JSONOutput {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to marshal results", zap.Error(err))
+		}
+		if cfg.OutputFile != "" {
+			if err := os.WriteFile(cfg.OutputFile, out, 0644); err != nil {
+				logger.GetLogger().Fatal("Failed to write output file", zap.Error(err))
+			}
+			fmt.Printf("Results written to: %s\n", cfg.OutputFile)
+		} else {
+			fmt.Println(string(out))
+		}
+	}
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	fmt.Fprintf(os.Stderr, "📋 Replayed %d queries: identical %d, different %d, failed %d, unrecorded %d\n",
+		len(results), counts[ReplayIdentical], counts[ReplayDifferent], counts[ReplayFailed], counts[ReplayUnrecorded])
+	if counts[ReplayDifferent] > 0 || counts[ReplayFailed] > 0 {
+		os.Exit(1)
+	}
+}
+
+// ReadCapture returns the DNS queries of a capture in order, each paired
+// with the first later response from the queried address carrying the same
+// ID and question.
+func ReadCapture(path string) ([]capturedQuery, error) {
+	r, err := pcap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var queries []capturedQuery
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := new(dns.Msg)
+		if err := m.Unpack(p.Payload); err != nil || len(m.Question) != 1 {
+			continue
+		}
+		if !m.Response {
+			queries = append(queries, capturedQuery{packet: p, query: m})
+			continue
+		}
+		for i := range queries {
+			q := &queries[i]
+			if q.response == nil && q.query.Id == m.Id && q.packet.Src == p.Dst && q.packet.Dst == p.Src &&
+				sameQuestion(q.query.Question[0], m.Question[0]) {
+				q.response = m
+				break
+			}
+		}
+	}
+	return queries, nil
+}
+
+func replayQuery(q capturedQuery, nameserver string, cfg config.Config, rec Recorder) ReplayResult {
+	question := q.query.Question[0]
+	result := ReplayResult{
+		Name:       question.Name,
+		Type:       dns.TypeToString[question.Qtype],
+		CapturedAt: q.packet.Time,
+	}
+	if q.response != nil {
+		result.Recorded = dns.RcodeToString[q.response.Rcode]
+	}
+	m := q.query.Copy()
+	m.Id = dns.Id()
+	r, _, err := Exchange(m, nameserver, cfg, rec)
+	if err != nil {
+		result.Status = ReplayFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Replayed = dns.RcodeToString[r.Rcode]
+	if q.response == nil {
+		result.Status = ReplayUnrecorded
+		result.Diff = DiffMessages(new(dns.Msg), r)
+		return result
+	}
+	result.Diff = DiffMessages(q.response, r)
+	result.Status = ReplayIdentical
+	if len(result.Diff) > 0 {
+		result.Status = ReplayDifferent
+	}
+	return result
+}
+
+// DiffMessages lists what changed from old to new: the response code, header
+// flags and the records of each section, ignoring TTLs and record order.
+// Removed lines start with "-", added ones with "+".
+func DiffMessages(old, new *dns.Msg) []string {
+	var diff []string
+	if old.Rcode != new.Rcode {
+		diff = append(diff, "- rcode "+dns.RcodeToString[old.Rcode], "+ rcode "+dns.RcodeToString[new.Rcode])
+	}
+	if of, nf := headerFlags(old), headerFlags(new); of != nf {
+		diff = append(diff, "- flags "+of, "+ flags "+nf)
+	}
+	sections := []struct {
+		name     string
+		old, new []dns.RR
+	}{
+		{"answer", old.Answer, new.Answer},
+		{"authority", old.Ns, new.Ns},
+		{"additional", old.Extra, new.Extra},
+	}
+	for _, section := range sections {
+		removed, added := diffRecords(section.old, section.new)
+		for _, rr := range removed {
+			diff = append(diff, fmt.Sprintf("- %s %s", section.name, rr))
+		}
+		for _, rr := range added {
+			diff = append(diff, fmt.Sprintf("+ %s %s", section.name, rr))
+		}
+	}
+	return diff
+}
+
+func headerFlags(m *dns.Msg) string {
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{m.Authoritative, "aa"},
+		{m.Truncated, "tc"},
+		{m.RecursionAvailable, "ra"},
+		{m.AuthenticatedData, "ad"},
+		{m.CheckingDisabled, "cd"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return "[" + strings.Join(flags, " ") + "]"
+}
+
+// diffRecords returns the records only in old and only in new, skipping the
+// OPT and TSIG pseudo records.
+func diffRecords(old, new []dns.RR) ([]string, []string) {
+	count := make(map[string]int)
+	for _, rr := range old {
+		if line, ok := recordLine(rr); ok {
+			count[line]++
+		}
+	}
+	var added []string
+	for _, rr := range new {
+		line, ok := recordLine(rr)
+		if !ok {
+			continue
+		}
+		if count[line] > 0 {
+			count[line]--
+			continue
+		}
+		added = append(added, line)
+	}
+	var removed []string
+	for line, n := range count {
+		for ; n > 0; n-- {
+			removed = append(removed, line)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	return removed, added
+}
+
+func recordLine(rr dns.RR) (string, bool) {
+	switch rr.Header().Rrtype {
+	case dns.TypeOPT, dns.TypeTSIG:
+		return "", false
+	}
+	rr = dns.Copy(rr)
+	rr.Header().Name = strings.ToLower(rr.Header().Name)
+	// Drop the TTL, the second field of the presentation format.
+	fields := strings.Fields(rr.String())
+	return strings.Join(append(fields[:1], fields[2:]...), " "), true
+}
+
+func sameQuestion(a, b dns.Question) bool {
+	return strings.EqualFold(a.Name, b.Name) && a.Qtype == b.Qtype && a.Qclass == b.Qclass
+}
+
+func printReplayResult(result ReplayResult) {
+	icon := map[string]string{
+		ReplayIdentical:  "✅",
+		ReplayDifferent:  "⚠️ ",
+		ReplayFailed:     "❌",
+		ReplayUnrecorded: "ℹ️ ",
+	}[result.Status]
+	fmt.Printf("%s %s %s %s", icon, displayDomain(result.Name), result.Type, result.Status)
+	switch {
+	case result.Error != "":
+		fmt.Printf(": %s", result.Error)
+	case result.Recorded != result.Replayed && result.Recorded != "":
+		fmt.Printf(" (%s → %s)", result.Recorded, result.Replayed)
+	}
+	fmt.Println()
+	for _, line := range result.Diff {
+		fmt.Printf("    %s\n", line)
+	}
+}
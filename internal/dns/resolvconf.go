@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// ResolvConf is the system resolver configuration used when no nameservers
+// are given on the command line.
+type ResolvConf struct {
+	Nameservers []string
+	Search      []string
+	Ndots       int
+	Timeout     time.Duration
+	Attempts    int
+}
+
+func LoadResolvConf(path string) (*ResolvConf, error) {
+	cc, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(cc.Servers) == 0 {
+		return nil, fmt.Errorf("%s lists no nameservers", path)
+	}
+	rc := &ResolvConf{
+		Search:   cc.Search,
+		Ndots:    cc.Ndots,
+		Timeout:  time.Duration(cc.Timeout) * time.Second,
+		Attempts: cc.Attempts,
+	}
+	for _, server := range cc.Servers {
+		rc.Nameservers = append(rc.Nameservers, net.JoinHostPort(server, cc.Port))
+	}
+	return rc, nil
+}
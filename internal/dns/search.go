@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"cDNS/internal/config"
+)
+
+// DefaultNdots matches the resolv.conf default.
+const DefaultNdots = 1
+
+// maxNdots is the largest ndots value glibc honours.
+const maxNdots = 15
+
+// SearchCandidates expands name with the search list the way the system
+// resolver does: names ending in a dot are absolute, names with at least
+// ndots dots are tried as given before the search domains, and shorter names
+// are tried with the search domains first.
+func SearchCandidates(name string, search []string, ndots int) []string {
+	if dns.IsFqdn(name) || len(search) == 0 {
+		return []string{dns.Fqdn(name)}
+	}
+	if ndots > maxNdots {
+		ndots = maxNdots
+	}
+	var expanded []string
+	for _, suffix := range search {
+		suffix = strings.Trim(suffix, ".")
+		if suffix == "" {
+			continue
+		}
+		candidate := dns.Fqdn(name + "." + suffix)
+		if _, ok := dns.IsDomainName(candidate); ok {
+			expanded = append(expanded, candidate)
+		}
+	}
+	if strings.Count(name, ".") >= ndots {
+		return append([]string{dns.Fqdn(name)}, expanded...)
+	}
+	return append(expanded, dns.Fqdn(name))
+}
+
+// SearchList converts search domains to A-labels and validates them like a
+// queried name, returning a *ValidationError for the first invalid one.
+func SearchList(search []string, mode ValidationMode) ([]string, error) {
+	list := make([]string, 0, len(search))
+	for _, suffix := range search {
+		ascii, err := ToASCII(suffix)
+		if err != nil {
+			return nil, &ValidationError{Reason: ReasonInvalidIDN, Label: suffix, Message: err.Error()}
+		}
+		if err := ValidateDomain(ascii, mode); err != nil {
+			return nil, err
+		}
+		list = append(list, ascii)
+	}
+	return list, nil
+}
+
+// resolveSearch returns the first candidate that nameserver answers for,
+// preferring names with address records over names that merely exist. When
+// no candidate exists name itself is returned so its errors are shown.
+func resolveSearch(name string, candidates []string, nameserver string, cfg config.Config, rec Recorder) string {
+	existing := ""
+	for _, candidate := range candidates {
+		m := new(dns.Msg)
+		m.SetQuestion(candidate, dns.TypeA)
+		m.RecursionDesired = true
+		r, _, err := Exchange(m, nameserver, cfg, rec)
+		if err != nil || r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		if len(r.Answer) > 0 {
+			return candidate
+		}
+		if existing == "" {
+			existing = candidate
+		}
+	}
+	if existing != "" {
+		return existing
+	}
+	return dns.Fqdn(name)
+}
@@ -0,0 +1,280 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"cDNS/internal/config"
+	"cDNS/internal/dnstap"
+)
+
+// defaultTimeout applies when cfg.Timeout is zero, matching dns.Client.
+const defaultTimeout = 2 * time.Second
+
+var defaultPorts = map[string]string{
+	"udp":   "53",
+	"tcp":   "53",
+	"tls":   "853",
+	"https": "443",
+}
+
+// Endpoint describes a nameserver together with the transport used to reach it.
+// Plain addresses default to UDP; "tcp://", "tls://" and "https://" prefixes
+// select DNS over TCP, DNS over TLS and DNS over HTTPS respectively.
+type Endpoint struct {
+	Transport string `json:"transport"`
+	Address   string `json:"address"`
+	URL       string `json:"url,omitempty"`
+}
+
+func ParseEndpoint(nameserver string) (Endpoint, error) {
+	transport := "udp"
+	rest := nameserver
+	if i := strings.Index(nameserver, "://"); i >= 0 {
+		transport = strings.ToLower(nameserver[:i])
+		rest = nameserver[i+3:]
+	}
+	port, ok := defaultPorts[transport]
+	if !ok {
+		return Endpoint{}, fmt.Errorf("unsupported transport %q", transport)
+	}
+	if transport == "https" {
+		u, err := url.Parse(nameserver)
+		if err != nil || u.Host == "" {
+			return Endpoint{}, fmt.Errorf("invalid DoH URL %q", nameserver)
+		}
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		return Endpoint{Transport: transport, Address: hostPortDefault(u.Host, port), URL: u.String()}, nil
+	}
+	if rest == "" {
+		return Endpoint{}, fmt.Errorf("empty nameserver address")
+	}
+	return Endpoint{Transport: transport, Address: hostPortDefault(rest, port)}, nil
+}
+
+func (e Endpoint) String() string {
+	if e.Transport == "https" {
+		return e.URL
+	}
+	if e.Transport == "udp" {
+		return e.Address
+	}
+	return e.Transport + "://" + e.Address
+}
+
+// hostPortDefault appends port to address unless it already carries one.
+func hostPortDefault(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), port)
+}
+
+// Exchange sends m to nameserver over the transport selected by its scheme and
+// returns the full response message. UDP answers with the TC bit set are
+// retried over TCP. cfg.IPVersion restricts hostname nameservers to one
+// address family, and every transport dials through the source-bound dialer.
+// The messages sent and received are passed to rec unless it is nil.
+func Exchange(m *dns.Msg, nameserver string, cfg config.Config, rec Recorder) (*dns.Msg, time.Duration, error) {
+	ep, err := ParseEndpoint(nameserver)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch ep.Transport {
+	case "https":
+		return exchangeHTTPS(m, ep, cfg, rec)
+	case "tls":
+		return exchangeClient(m, ep.Address, "tcp", true, cfg, rec)
+	case "tcp":
+		return exchangeClient(m, ep.Address, "tcp", false, cfg, rec)
+	}
+	r, rtt, err := exchangeClient(m, ep.Address, "udp", false, cfg, rec)
+	if err == nil && r.Truncated {
+		return exchangeClient(m, ep.Address, "tcp", false, cfg, rec)
+	}
+	return r, rtt, err
+}
+
+// network appends the address family to a base network name, e.g. "udp6".
+func network(base string, family int) string {
+	if family == 4 || family == 6 {
+		return fmt.Sprintf("%s%d", base, family)
+	}
+	return base
+}
+
+func exchangeClient(m *dns.Msg, address, base string, useTLS bool, cfg config.Config, rec Recorder) (*dns.Msg, time.Duration, error) {
+	netName := network(base, cfg.IPVersion)
+	if useTLS {
+		netName += "-tls"
+	}
+	d, err := dialer(cfg, netName, address)
+	if err != nil {
+		return nil, 0, err
+	}
+	c := &dns.Client{Net: netName, Timeout: cfg.Timeout, Dialer: d}
+	if rec != nil {
+		return exchangeRecorded(c, m, address, rec)
+	}
+	return c.Exchange(m, address)
+}
+
+func exchangeHTTPS(m *dns.Msg, ep Endpoint, cfg config.Config, rec Recorder) (*dns.Msg, time.Duration, error) {
+	// RFC 8484 recommends a zero message ID to improve HTTP cache friendliness.
+	id := m.Id
+	m.Id = 0
+	packed, err := m.Pack()
+	m.Id = id
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	var local, remote netip.AddrPort
+	if rec != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				local, remote = addrPort(info.Conn.LocalAddr()), addrPort(info.Conn.RemoteAddr())
+			},
+		}))
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	client := &http.Client{Timeout: timeout, Transport: httpsTransport(ep, cfg)}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, rtt, err
+	}
+	if rec != nil {
+		rec.Query(dnstap.DOH, local, remote, start, packed)
+		if resp.StatusCode == http.StatusOK {
+			rec.Response(dnstap.DOH, local, remote, start, start.Add(rtt), body)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, rtt, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, rtt, err
+	}
+	r.Id = id
+	return r, rtt, nil
+}
+
+// httpsKey identifies the DoH transports that can share connections: the
+// same endpoint reached with the same address family and source binding.
+type httpsKey struct {
+	url           string
+	family        int
+	sourceAddress string
+	iface         string
+	timeout       time.Duration
+}
+
+var (
+	httpsMu         sync.Mutex
+	httpsTransports = make(map[httpsKey]*http.Transport)
+)
+
+// httpsTransport returns the transport for ep under cfg, created on first
+// use and kept so later exchanges reuse its connections.
+func httpsTransport(ep Endpoint, cfg config.Config) *http.Transport {
+	key := httpsKey{
+		url:           ep.URL,
+		family:        cfg.IPVersion,
+		sourceAddress: cfg.SourceAddress,
+		iface:         cfg.Interface,
+		timeout:       cfg.Timeout,
+	}
+	httpsMu.Lock()
+	defer httpsMu.Unlock()
+	if transport, ok := httpsTransports[key]; ok {
+		return transport
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			netName := network("tcp", cfg.IPVersion)
+			d, err := dialer(cfg, netName, address)
+			if err != nil {
+				return nil, err
+			}
+			return d.DialContext(ctx, netName, address)
+		},
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   90 * time.Second,
+	}
+	httpsTransports[key] = transport
+	return transport
+}
+
+// Transfer requests a full zone transfer (AXFR) of zone from a plain or
+// tcp:// nameserver through the source-bound dialer and returns every record
+// received, including the leading and trailing SOA.
+func Transfer(zone, nameserver string, cfg config.Config) ([]dns.RR, error) {
+	ep, err := ParseEndpoint(nameserver)
+	if err != nil {
+		return nil, err
+	}
+	if ep.Transport != "udp" && ep.Transport != "tcp" {
+		return nil, fmt.Errorf("zone transfers are not supported over %s", ep.Transport)
+	}
+	netName := network("tcp", cfg.IPVersion)
+	d, err := dialer(cfg, netName, ep.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.Dial(netName, ep.Address)
+	if err != nil {
+		return nil, err
+	}
+	t := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: cfg.Timeout}
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(zone))
+	envelopes, err := t.In(m, ep.Address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var rrs []dns.RR
+	for env := range envelopes {
+		if env.Error != nil {
+			err = env.Error
+			continue
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
@@ -19,53 +19,61 @@ var RecordTypes = map[string]uint16{
 	"NSEC":   47,
 }
 
-var PopularDNSServers = []string{
-	"8.8.8.8",
-	"8.8.4.4",
-	"1.1.1.1",
-	"1.0.0.1",
-	"9.9.9.9",
-	"149.112.112.112",
-	"208.67.222.222",
-	"208.67.220.220",
-	"76.76.19.19",
-	"76.223.100.101",
-	"94.140.14.14",
-	"94.140.15.15",
-	"77.88.8.8",
-	"77.88.8.1",
+// FilterRecordTypes are only queried when a record filter asks for them.
+// NSEC3 records only appear in denial of existence answers; the apex
+// NSEC3PARAM record announces their parameters.
+var FilterRecordTypes = map[string]uint16{
+	"NSEC3PARAM": 51,
+}
+
+// RecordType returns the type code of name, one of RecordTypes or
+// FilterRecordTypes.
+func RecordType(name string) (uint16, bool) {
+	if t, ok := RecordTypes[name]; ok {
+		return t, true
+	}
+	t, ok := FilterRecordTypes[name]
+	return t, ok
 }
 
 type Result struct {
 	Nameserver string                    `json:"nameserver"`
 	Domain     string                    `json:"domain"`
+	Unicode    string                    `json:"unicode_domain,omitempty"`
+	Query      string                    `json:"query,omitempty"`
+	Candidates []string                  `json:"search_candidates,omitempty"`
 	QueryTime  time.Time                 `json:"query_time"`
 	Records    map[string][]ParsedRecord `json:"records"`
 	Errors     map[string]string         `json:"errors,omitempty"`
+	Chain      *Chain                    `json:"chain,omitempty"`
 	Statistics Statistics                `json:"statistics"`
 }
 
 type ParsedRecord struct {
-	TTL      uint32 `json:"ttl"`
-	Type     string `json:"type"`
-	Address  string `json:"address,omitempty"`
-	Host     string `json:"host,omitempty"`
-	Pref     uint16 `json:"pref,omitempty"`
-	Text     string `json:"text,omitempty"`
-	Target   string `json:"target,omitempty"`
-	Port     uint16 `json:"port,omitempty"`
-	Priority uint16 `json:"priority,omitempty"`
-	Weight   uint16 `json:"weight,omitempty"`
-	Serial   uint32 `json:"serial,omitempty"`
-	Refresh  uint32 `json:"refresh,omitempty"`
-	Retry    uint32 `json:"retry,omitempty"`
-	Expire   uint32 `json:"expire,omitempty"`
-	Minimum  uint32 `json:"minimum,omitempty"`
-	MName    string `json:"mname,omitempty"`
-	RName    string `json:"rname,omitempty"`
-	Tag      uint8  `json:"tag,omitempty"`
-	Value    string `json:"value,omitempty"`
-	RawData  string `json:"raw_data,omitempty"`
+	TTL        uint32   `json:"ttl"`
+	Type       string   `json:"type"`
+	Address    string   `json:"address,omitempty"`
+	Host       string   `json:"host,omitempty"`
+	Pref       uint16   `json:"pref,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Port       uint16   `json:"port,omitempty"`
+	Priority   uint16   `json:"priority,omitempty"`
+	Weight     uint16   `json:"weight,omitempty"`
+	Serial     uint32   `json:"serial,omitempty"`
+	Refresh    uint32   `json:"refresh,omitempty"`
+	Retry      uint32   `json:"retry,omitempty"`
+	Expire     uint32   `json:"expire,omitempty"`
+	Minimum    uint32   `json:"minimum,omitempty"`
+	MName      string   `json:"mname,omitempty"`
+	RName      string   `json:"rname,omitempty"`
+	Flag       uint8    `json:"flag,omitempty"`
+	Tag        string   `json:"tag,omitempty"`
+	Value      string   `json:"value,omitempty"`
+	Types      []string `json:"types,omitempty"`
+	Iterations uint16   `json:"iterations,omitempty"`
+	Salt       string   `json:"salt,omitempty"`
+	RawData    string   `json:"raw_data,omitempty"`
 }
 
 type Statistics struct {
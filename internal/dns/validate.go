@@ -0,0 +1,147 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationMode selects how strictly names are checked.
+type ValidationMode string
+
+const (
+	// ModeDNSName accepts any name that can be queried: underscores anywhere,
+	// wildcards and RFC 2317 style slashes.
+	ModeDNSName ValidationMode = "dns"
+	// ModeHostname enforces the letter-digit-hyphen rules of RFC 952/1123,
+	// allowing underscores only in leading labels of SRV/TXT-style names such
+	// as _sip._tcp.example.com or _dmarc.example.com (RFC 8552).
+	ModeHostname ValidationMode = "hostname"
+)
+
+type ValidationReason string
+
+const (
+	ReasonEmpty        ValidationReason = "empty"
+	ReasonNameTooLong  ValidationReason = "name_too_long"
+	ReasonLabelTooLong ValidationReason = "label_too_long"
+	ReasonEmptyLabel   ValidationReason = "empty_label"
+	ReasonBadCharacter ValidationReason = "bad_character"
+	ReasonHyphen       ValidationReason = "hyphen"
+	ReasonNumericTLD   ValidationReason = "numeric_tld"
+	ReasonUnderscore   ValidationReason = "underscore"
+	ReasonInvalidIDN   ValidationReason = "invalid_idn"
+	ReasonUnknownMode  ValidationReason = "unknown_mode"
+)
+
+const (
+	maxNameLength  = 253
+	maxLabelLength = 63
+)
+
+// ValidationError explains why a name was rejected.
+type ValidationError struct {
+	Reason  ValidationReason `json:"reason"`
+	Label   string           `json:"label,omitempty"`
+	Message string           `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(reason ValidationReason, label, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Reason: reason, Label: label, Message: fmt.Sprintf(format, args...)}
+}
+
+func ParseValidationMode(mode string) (ValidationMode, error) {
+	switch ValidationMode(strings.ToLower(mode)) {
+	case "", ModeDNSName:
+		return ModeDNSName, nil
+	case ModeHostname:
+		return ModeHostname, nil
+	}
+	return "", invalid(ReasonUnknownMode, "", "unknown name validation mode %q (use %q or %q)", mode, ModeDNSName, ModeHostname)
+}
+
+// ValidateDomain checks domain, in A-label form, and returns a
+// *ValidationError describing the first problem found. Single-label names
+// such as "localhost" or "com" are valid, as is the root "." in DNS name mode.
+func ValidateDomain(domain string, mode ValidationMode) error {
+	if domain == "" {
+		return invalid(ReasonEmpty, "", "domain is empty")
+	}
+	if domain == "." && mode == ModeDNSName {
+		return nil
+	}
+	name := strings.TrimSuffix(domain, ".")
+	if len(name) > maxNameLength {
+		return invalid(ReasonNameTooLong, "", "domain is %d characters long, the maximum is %d", len(name), maxNameLength)
+	}
+	labels := strings.Split(name, ".")
+	attrleaf := true
+	for i, label := range labels {
+		if label == "" {
+			return invalid(ReasonEmptyLabel, label, "domain %q contains an empty label", domain)
+		}
+		if len(label) > maxLabelLength {
+			return invalid(ReasonLabelTooLong, label, "label %q is %d characters long, the maximum is %d", label, len(label), maxLabelLength)
+		}
+		if hasACEPrefix(label) {
+			if _, err := idnaProfile.ToUnicode(label); err != nil {
+				return invalid(ReasonInvalidIDN, label, "label %q is not valid punycode: %s", label, idnaError(err))
+			}
+		}
+		underscore := strings.HasPrefix(label, "_")
+		if !underscore {
+			attrleaf = false
+		}
+		for _, r := range label {
+			if !labelRuneAllowed(r, mode) {
+				return invalid(ReasonBadCharacter, label, "label %q contains invalid character %q", label, r)
+			}
+		}
+		if mode != ModeHostname {
+			continue
+		}
+		if strings.Contains(label, "_") {
+			if !underscore || !attrleaf || i == len(labels)-1 {
+				return invalid(ReasonUnderscore, label, "label %q: underscores are only allowed in leading labels of SRV/TXT-style names such as _service._tcp", label)
+			}
+			continue
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return invalid(ReasonHyphen, label, "label %q must not start or end with a hyphen", label)
+		}
+		if len(label) >= 4 && label[2:4] == "--" && !hasACEPrefix(label) {
+			return invalid(ReasonHyphen, label, "label %q has hyphens in the third and fourth position, which is reserved for IDNA", label)
+		}
+	}
+	if tld := labels[len(labels)-1]; isNumeric(tld) {
+		return invalid(ReasonNumericTLD, tld, "top-level label %q is all-numeric", tld)
+	}
+	return nil
+}
+
+// IsValidDomain reports whether domain is a valid DNS name.
+func IsValidDomain(domain string) bool {
+	return ValidateDomain(domain, ModeDNSName) == nil
+}
+
+func labelRuneAllowed(r rune, mode ValidationMode) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		return true
+	case r == '*', r == '/':
+		return mode == ModeDNSName
+	}
+	return false
+}
+
+func isNumeric(label string) bool {
+	for _, r := range label {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+// Package dnstap logs DNS messages as dnstap protobuf frames using Frame
+// Streams encoding, to a Unix socket, a TCP collector or a file.
+package dnstap
+
+import (
+	"net/netip"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MessageType is the dnstap Message.Type of a logged message.
+type MessageType uint64
+
+const (
+	AuthQuery         MessageType = 1
+	AuthResponse      MessageType = 2
+	ResolverQuery     MessageType = 3
+	ResolverResponse  MessageType = 4
+	ClientQuery       MessageType = 5
+	ClientResponse    MessageType = 6
+	ForwarderQuery    MessageType = 7
+	ForwarderResponse MessageType = 8
+	StubQuery         MessageType = 9
+	StubResponse      MessageType = 10
+	ToolQuery         MessageType = 11
+	ToolResponse      MessageType = 12
+)
+
+// Protocol is the dnstap SocketProtocol a message was carried over.
+type Protocol uint64
+
+const (
+	UDP Protocol = 1
+	TCP Protocol = 2
+	DOT Protocol = 3
+	DOH Protocol = 4
+)
+
+// ContentType identifies dnstap payloads in Frame Streams control frames.
+const ContentType = "protobuf:dnstap.Dnstap"
+
+// Message is one dnstap Message. QueryAddr is always the address of the side
+// that sent the query, whichever message is logged.
+type Message struct {
+	Type            MessageType
+	Protocol        Protocol
+	QueryAddr       netip.AddrPort
+	ResponseAddr    netip.AddrPort
+	QueryTime       time.Time
+	ResponseTime    time.Time
+	QueryMessage    []byte
+	ResponseMessage []byte
+}
+
+// Dnstap and Message field numbers from dnstap.proto.
+const (
+	fieldIdentity = 1
+	fieldVersion  = 2
+	fieldMessage  = 14
+	fieldType     = 15
+
+	fieldMessageType      = 1
+	fieldSocketFamily     = 2
+	fieldSocketProtocol   = 3
+	fieldQueryAddress     = 4
+	fieldResponseAddress  = 5
+	fieldQueryPort        = 6
+	fieldResponsePort     = 7
+	fieldQueryTimeSec     = 8
+	fieldQueryTimeNsec    = 9
+	fieldQueryMessage     = 10
+	fieldResponseTimeSec  = 12
+	fieldResponseTimeNsec = 13
+	fieldResponseMessage  = 14
+
+	typeMessage = 1
+	familyINET  = 1
+	familyINET6 = 2
+)
+
+// Marshal encodes m as a Dnstap protobuf.
+func (m Message) Marshal(identity, version string) []byte {
+	var msg []byte
+	msg = appendVarint(msg, fieldMessageType, uint64(m.Type))
+	if m.Protocol != 0 {
+		msg = appendVarint(msg, fieldSocketProtocol, uint64(m.Protocol))
+	}
+	if addr := m.QueryAddr.Addr().Unmap(); addr.IsValid() {
+		family := uint64(familyINET)
+		if addr.Is6() {
+			family = familyINET6
+		}
+		msg = appendVarint(msg, fieldSocketFamily, family)
+		msg = appendBytes(msg, fieldQueryAddress, addr.AsSlice())
+		msg = appendVarint(msg, fieldQueryPort, uint64(m.QueryAddr.Port()))
+	}
+	if addr := m.ResponseAddr.Addr().Unmap(); addr.IsValid() {
+		msg = appendBytes(msg, fieldResponseAddress, addr.AsSlice())
+		msg = appendVarint(msg, fieldResponsePort, uint64(m.ResponseAddr.Port()))
+	}
+	if !m.QueryTime.IsZero() {
+		msg = appendVarint(msg, fieldQueryTimeSec, uint64(m.QueryTime.Unix()))
+		msg = protowire.AppendTag(msg, fieldQueryTimeNsec, protowire.Fixed32Type)
+		msg = protowire.AppendFixed32(msg, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		msg = appendBytes(msg, fieldQueryMessage, m.QueryMessage)
+	}
+	if !m.ResponseTime.IsZero() {
+		msg = appendVarint(msg, fieldResponseTimeSec, uint64(m.ResponseTime.Unix()))
+		msg = protowire.AppendTag(msg, fieldResponseTimeNsec, protowire.Fixed32Type)
+		msg = protowire.AppendFixed32(msg, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		msg = appendBytes(msg, fieldResponseMessage, m.ResponseMessage)
+	}
+
+	var b []byte
+	if identity != "" {
+		b = appendBytes(b, fieldIdentity, []byte(identity))
+	}
+	if version != "" {
+		b = appendBytes(b, fieldVersion, []byte(version))
+	}
+	b = appendBytes(b, fieldMessage, msg)
+	return appendVarint(b, fieldType, typeMessage)
+}
+
+func appendVarint(b []byte, field protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, field, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytes(b []byte, field protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
@@ -0,0 +1,234 @@
+package dnstap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testQuery    = []byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	testResponse = []byte{0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}
+)
+
+func checkMessage(t *testing.T, got *Message, want Message) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("got no message")
+	}
+	if got.Type != want.Type || got.Protocol != want.Protocol {
+		t.Errorf("type/protocol = %d/%d, want %d/%d", got.Type, got.Protocol, want.Type, want.Protocol)
+	}
+	if got.QueryAddr != want.QueryAddr || got.ResponseAddr != want.ResponseAddr {
+		t.Errorf("addresses = %s -> %s, want %s -> %s", got.QueryAddr, got.ResponseAddr, want.QueryAddr, want.ResponseAddr)
+	}
+	if !got.QueryTime.Equal(want.QueryTime) || !got.ResponseTime.Equal(want.ResponseTime) {
+		t.Errorf("times = %v/%v, want %v/%v", got.QueryTime, got.ResponseTime, want.QueryTime, want.ResponseTime)
+	}
+	if !bytes.Equal(got.QueryMessage, want.QueryMessage) || !bytes.Equal(got.ResponseMessage, want.ResponseMessage) {
+		t.Errorf("messages = %x/%x, want %x/%x", got.QueryMessage, got.ResponseMessage, want.QueryMessage, want.ResponseMessage)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	queryTime := time.Unix(1700000000, 123456789)
+	tests := []Message{
+		{
+			Type:         ClientQuery,
+			Protocol:     UDP,
+			QueryAddr:    netip.MustParseAddrPort("192.0.2.1:53000"),
+			ResponseAddr: netip.MustParseAddrPort("198.51.100.53:53"),
+			QueryTime:    queryTime,
+			QueryMessage: testQuery,
+		},
+		{
+			Type:            ResolverResponse,
+			Protocol:        DOH,
+			QueryAddr:       netip.MustParseAddrPort("[2001:db8::1]:40000"),
+			ResponseAddr:    netip.MustParseAddrPort("[2001:db8::53]:443"),
+			QueryTime:       queryTime,
+			ResponseTime:    queryTime.Add(25 * time.Millisecond),
+			ResponseMessage: testResponse,
+		},
+		{
+			Type: AuthResponse,
+		},
+	}
+	for _, want := range tests {
+		got, err := Unmarshal(want.Marshal("host", "cDNS"))
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		checkMessage(t, got, want)
+	}
+}
+
+func TestUnmarshalMappedAddress(t *testing.T) {
+	m := Message{Type: ClientQuery, QueryAddr: netip.MustParseAddrPort("[::ffff:192.0.2.1]:53")}
+	got, err := Unmarshal(m.Marshal("", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParseAddrPort("192.0.2.1:53"); got.QueryAddr != want {
+		t.Errorf("QueryAddr = %s, want %s", got.QueryAddr, want)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.tap")
+	w, err := Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := netip.MustParseAddrPort("192.0.2.1:53000")
+	remote := netip.MustParseAddrPort("198.51.100.53:53")
+	queryTime := time.Unix(1700000000, 1000)
+	responseTime := queryTime.Add(time.Millisecond)
+	w.Query(UDP, local, remote, queryTime, testQuery)
+	w.Resolver().Response(TCP, local, remote, queryTime, responseTime, testResponse)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := []Message{
+		{Type: ClientQuery, Protocol: UDP, QueryAddr: local, ResponseAddr: remote, QueryTime: queryTime, QueryMessage: testQuery},
+		{Type: ResolverResponse, Protocol: TCP, QueryAddr: local, ResponseAddr: remote, QueryTime: queryTime, ResponseTime: responseTime, ResponseMessage: testResponse},
+	}
+	for _, m := range want {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		checkMessage(t, got, m)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next at STOP = %v, want io.EOF", err)
+	}
+}
+
+func TestOpenFileRejectsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "garbage.tap")
+	if err := os.WriteFile(path, []byte("not a dnstap file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := OpenFile(path); err == nil {
+		r.Close()
+		t.Fatal("OpenFile accepted a file without a START frame")
+	}
+}
+
+// listenUnix returns a Unix socket listener in a short temporary directory,
+// keeping the path within the sun_path limit.
+func listenUnix(t *testing.T) (net.Listener, string) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "dnstap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln, path
+}
+
+// collect plays the receiving side of a bidirectional Frame Streams
+// connection and sends the messages it reads, then nil, on ch.
+func collect(t *testing.T, ln net.Listener, ch chan<- *Message) {
+	defer close(ch)
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	if typ, err := readControl(r); err != nil || typ != controlReady {
+		t.Errorf("first control frame = %d, %v; want READY", typ, err)
+		return
+	}
+	if err := writeControl(conn, controlAccept); err != nil {
+		t.Error(err)
+		return
+	}
+	if typ, err := readControl(r); err != nil || typ != controlStart {
+		t.Errorf("second control frame = %d, %v; want START", typ, err)
+		return
+	}
+	reader := &Reader{r: r}
+	for {
+		m, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ch <- m
+	}
+	if err := writeControl(conn, controlFinish); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSocketHandshake(t *testing.T) {
+	ln, path := listenUnix(t)
+	ch := make(chan *Message, 4)
+	go collect(t, ln, ch)
+
+	w, err := Open("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := netip.MustParseAddrPort("127.0.0.1:53")
+	remote := netip.MustParseAddrPort("127.0.0.1:40000")
+	queryTime := time.Unix(1700000000, 0)
+	w.Log(Message{Type: ClientQuery, Protocol: UDP, QueryAddr: remote, ResponseAddr: local, QueryTime: queryTime, QueryMessage: testQuery})
+	w.Log(Message{Type: ClientResponse, Protocol: UDP, QueryAddr: remote, ResponseAddr: local, QueryTime: queryTime, ResponseTime: queryTime, ResponseMessage: testResponse})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*Message
+	for m := range ch {
+		got = append(got, m)
+	}
+	if len(got) != 2 {
+		t.Fatalf("collector read %d messages, want 2", len(got))
+	}
+	checkMessage(t, got[0], Message{Type: ClientQuery, Protocol: UDP, QueryAddr: remote, ResponseAddr: local, QueryTime: queryTime, QueryMessage: testQuery})
+	checkMessage(t, got[1], Message{Type: ClientResponse, Protocol: UDP, QueryAddr: remote, ResponseAddr: local, QueryTime: queryTime, ResponseTime: queryTime, ResponseMessage: testResponse})
+}
+
+func TestSocketHandshakeRejected(t *testing.T) {
+	ln, path := listenUnix(t)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readControl(conn)
+		writeControl(conn, controlFinish)
+	}()
+	if w, err := Open("unix://" + path); err == nil {
+		w.Close()
+		t.Fatal("Open succeeded although the collector did not send ACCEPT")
+	}
+}
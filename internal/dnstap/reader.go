@@ -0,0 +1,204 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const maxFrameLength = 1 << 20
+
+// Reader returns the messages of a dnstap file, a unidirectional Frame
+// Streams written by Writer or by dnstap-capable servers.
+type Reader struct {
+	r    *bufio.Reader
+	file *os.File
+}
+
+// OpenFile opens the dnstap file at path.
+func OpenFile(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	r.file = f
+	return r, nil
+}
+
+// NewReader reads the START control frame from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	typ, err := readControl(reader.r)
+	if err != nil {
+		return nil, fmt.Errorf("not a dnstap file: %v", err)
+	}
+	if typ != controlStart {
+		return nil, fmt.Errorf("expected START, got control frame type %d", typ)
+	}
+	return reader, nil
+}
+
+// Next returns the next message. It returns io.EOF at the STOP frame or the
+// end of the file.
+func (r *Reader) Next() (*Message, error) {
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated dnstap file")
+			}
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr)
+		if n == 0 {
+			// Escape sequence: a control frame follows.
+			if _, err := io.ReadFull(r.r, hdr); err != nil {
+				return nil, errors.New("truncated dnstap file")
+			}
+			n = binary.BigEndian.Uint32(hdr)
+			if n < 4 || n > maxControlLength {
+				return nil, fmt.Errorf("invalid control frame length %d", n)
+			}
+			payload := make([]byte, n)
+			if _, err := io.ReadFull(r.r, payload); err != nil {
+				return nil, errors.New("truncated dnstap file")
+			}
+			if binary.BigEndian.Uint32(payload) == controlStop {
+				return nil, io.EOF
+			}
+			continue
+		}
+		if n > maxFrameLength {
+			return nil, fmt.Errorf("invalid frame length %d", n)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r.r, frame); err != nil {
+			return nil, errors.New("truncated dnstap file")
+		}
+		m, err := Unmarshal(frame)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			return m, nil
+		}
+	}
+}
+
+// Close closes the file opened by OpenFile.
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// Unmarshal decodes a Dnstap protobuf. It returns nil for Dnstap payloads
+// that carry no message.
+func Unmarshal(b []byte) (*Message, error) {
+	var inner []byte
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		if num == fieldMessage && typ == protowire.BytesType {
+			inner = v
+		}
+	})
+	if err != nil || inner == nil {
+		return nil, err
+	}
+
+	m := &Message{}
+	var queryAddr, responseAddr netip.Addr
+	var queryPort, responsePort uint16
+	var querySec, responseSec uint64
+	var queryNsec, responseNsec uint64
+	err = consumeFields(inner, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) {
+		switch num {
+		case fieldMessageType:
+			m.Type = MessageType(n)
+		case fieldSocketProtocol:
+			m.Protocol = Protocol(n)
+		case fieldQueryAddress:
+			queryAddr, _ = netip.AddrFromSlice(v)
+		case fieldResponseAddress:
+			responseAddr, _ = netip.AddrFromSlice(v)
+		case fieldQueryPort:
+			queryPort = uint16(n)
+		case fieldResponsePort:
+			responsePort = uint16(n)
+		case fieldQueryTimeSec:
+			querySec = n
+		case fieldQueryTimeNsec:
+			queryNsec = n
+		case fieldResponseTimeSec:
+			responseSec = n
+		case fieldResponseTimeNsec:
+			responseNsec = n
+		case fieldQueryMessage:
+			m.QueryMessage = v
+		case fieldResponseMessage:
+			m.ResponseMessage = v
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if queryAddr.IsValid() {
+		m.QueryAddr = netip.AddrPortFrom(queryAddr, queryPort)
+	}
+	if responseAddr.IsValid() {
+		m.ResponseAddr = netip.AddrPortFrom(responseAddr, responsePort)
+	}
+	if querySec > 0 {
+		m.QueryTime = time.Unix(int64(querySec), int64(queryNsec))
+	}
+	if responseSec > 0 {
+		m.ResponseTime = time.Unix(int64(responseSec), int64(responseNsec))
+	}
+	return m, nil
+}
+
+// consumeFields calls fn with every field of a protobuf message: v holds
+// the data of length-delimited fields and n the value of numeric ones.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		var v []byte
+		var n uint64
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var f uint32
+			f, l = protowire.ConsumeFixed32(b)
+			n = uint64(f)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		fn(num, typ, v, n)
+	}
+	return nil
+}
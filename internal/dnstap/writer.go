@@ -0,0 +1,304 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"cDNS/internal/logger"
+)
+
+// Frame Streams control frame types.
+const (
+	controlAccept = 1
+	controlStart  = 2
+	controlStop   = 3
+	controlReady  = 4
+	controlFinish = 5
+
+	fieldContentType = 1
+)
+
+const (
+	queueSize        = 4096
+	handshakeTimeout = 5 * time.Second
+	reconnectDelay   = 5 * time.Second
+	maxControlLength = 512
+)
+
+// Writer logs DNS messages to a dnstap output. Frames are sent by a
+// background goroutine so logging never delays DNS traffic; messages are
+// dropped while the output is unavailable or its queue is full. Query and
+// Response log exchanges cDNS makes itself, as CLIENT_* messages unless the
+// writer was obtained with Resolver. It is safe for concurrent use.
+type Writer struct {
+	out      *output
+	query    MessageType
+	response MessageType
+}
+
+type output struct {
+	network  string
+	address  string
+	identity string
+
+	mu      sync.RWMutex
+	closed  bool
+	frames  chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// Open connects to the output described by spec: "unix:///path" for a Unix
+// socket, "tcp://host:port" for a TCP collector, or a file path (optionally
+// "file://path"). Sockets use the bidirectional Frame Streams handshake and
+// are reconnected after failures; files are written unidirectionally.
+func Open(spec string) (*Writer, error) {
+	network, address := "file", spec
+	if scheme, rest, ok := strings.Cut(spec, "://"); ok {
+		switch scheme {
+		case "unix", "tcp", "file":
+			network, address = scheme, rest
+		default:
+			return nil, fmt.Errorf("unsupported dnstap output %q", spec)
+		}
+	}
+	if address == "" {
+		return nil, fmt.Errorf("empty dnstap output address in %q", spec)
+	}
+	identity, _ := os.Hostname()
+	out := &output{
+		network:  network,
+		address:  address,
+		identity: identity,
+		frames:   make(chan []byte, queueSize),
+		done:     make(chan struct{}),
+	}
+	s, err := out.connect()
+	if err != nil {
+		return nil, err
+	}
+	go out.run(s)
+	return &Writer{out: out, query: ClientQuery, response: ClientResponse}, nil
+}
+
+// Resolver returns a writer sharing w's output that logs the exchanges cDNS
+// makes as RESOLVER_QUERY and RESOLVER_RESPONSE, as used for upstream
+// queries in serve mode.
+func (w *Writer) Resolver() *Writer {
+	return &Writer{out: w.out, query: ResolverQuery, response: ResolverResponse}
+}
+
+// Query logs msg sent from local to remote at ts.
+func (w *Writer) Query(protocol Protocol, local, remote netip.AddrPort, ts time.Time, msg []byte) {
+	w.Log(Message{
+		Type:         w.query,
+		Protocol:     protocol,
+		QueryAddr:    local,
+		ResponseAddr: remote,
+		QueryTime:    ts,
+		QueryMessage: msg,
+	})
+}
+
+// Response logs msg received by local from remote at ts, answering a query
+// sent at queryTime.
+func (w *Writer) Response(protocol Protocol, local, remote netip.AddrPort, queryTime, ts time.Time, msg []byte) {
+	w.Log(Message{
+		Type:            w.response,
+		Protocol:        protocol,
+		QueryAddr:       local,
+		ResponseAddr:    remote,
+		QueryTime:       queryTime,
+		ResponseTime:    ts,
+		ResponseMessage: msg,
+	})
+}
+
+// Log queues m for the output.
+func (w *Writer) Log(m Message) {
+	frame := m.Marshal(w.out.identity, "cDNS")
+	w.out.mu.RLock()
+	defer w.out.mu.RUnlock()
+	if w.out.closed {
+		return
+	}
+	select {
+	case w.out.frames <- frame:
+	default:
+		w.out.dropped.Add(1)
+	}
+}
+
+// Close flushes the queued messages and ends the stream. It closes the
+// output shared with writers obtained from Resolver.
+func (w *Writer) Close() error {
+	w.out.mu.Lock()
+	if w.out.closed {
+		w.out.mu.Unlock()
+		return nil
+	}
+	w.out.closed = true
+	close(w.out.frames)
+	w.out.mu.Unlock()
+	<-w.out.done
+	if dropped := w.out.dropped.Load(); dropped > 0 {
+		logger.GetLogger().Warn("Dropped dnstap messages", zap.Uint64("dropped", dropped))
+	}
+	return nil
+}
+
+// stream is an established Frame Streams connection or file.
+type stream struct {
+	conn          io.ReadWriteCloser
+	w             *bufio.Writer
+	bidirectional bool
+}
+
+func (o *output) connect() (*stream, error) {
+	if o.network == "file" {
+		f, err := os.Create(o.address)
+		if err != nil {
+			return nil, err
+		}
+		s := &stream{conn: f, w: bufio.NewWriter(f)}
+		if err := writeControl(s.w, controlStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return s, s.w.Flush()
+	}
+
+	conn, err := net.DialTimeout(o.network, o.address, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	s := &stream{conn: conn, w: bufio.NewWriter(conn), bidirectional: true}
+	if err := s.handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dnstap handshake with %s: %v", o.address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return s, nil
+}
+
+func (s *stream) handshake() error {
+	if err := writeControl(s.w, controlReady); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	typ, err := readControl(s.conn)
+	if err != nil {
+		return err
+	}
+	if typ != controlAccept {
+		return fmt.Errorf("expected ACCEPT, got control frame type %d", typ)
+	}
+	if err := writeControl(s.w, controlStart); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// finish ends the stream with STOP and, on sockets, waits for FINISH.
+func (s *stream) finish() {
+	defer s.conn.Close()
+	if err := writeControl(s.w, controlStop); err != nil {
+		return
+	}
+	if err := s.w.Flush(); err != nil || !s.bidirectional {
+		return
+	}
+	if conn, ok := s.conn.(net.Conn); ok {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	}
+	readControl(s.conn)
+}
+
+func (o *output) run(s *stream) {
+	defer close(o.done)
+	var retry time.Time
+	for frame := range o.frames {
+		if s == nil {
+			if o.network == "file" || time.Now().Before(retry) {
+				o.dropped.Add(1)
+				continue
+			}
+			var err error
+			if s, err = o.connect(); err != nil {
+				logger.GetLogger().Warn("Failed to reconnect dnstap output", zap.String("address", o.address), zap.Error(err))
+				retry = time.Now().Add(reconnectDelay)
+				o.dropped.Add(1)
+				continue
+			}
+		}
+		err := writeData(s.w, frame)
+		if err == nil && len(o.frames) == 0 {
+			err = s.w.Flush()
+		}
+		if err != nil {
+			logger.GetLogger().Warn("Failed to write dnstap frame", zap.String("address", o.address), zap.Error(err))
+			s.conn.Close()
+			s = nil
+			retry = time.Now().Add(reconnectDelay)
+			o.dropped.Add(1)
+		}
+	}
+	if s != nil {
+		s.finish()
+	}
+}
+
+func writeData(w io.Writer, payload []byte) error {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// writeControl writes a control frame carrying the dnstap content type,
+// except for STOP which has no fields.
+func writeControl(w io.Writer, typ uint32) error {
+	payload := binary.BigEndian.AppendUint32(nil, typ)
+	if typ != controlStop {
+		payload = binary.BigEndian.AppendUint32(payload, fieldContentType)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(ContentType)))
+		payload = append(payload, ContentType...)
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// readControl reads a control frame and returns its type.
+func readControl(r io.Reader) (uint32, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint32(hdr) != 0 {
+		return 0, errors.New("expected a control frame")
+	}
+	n := binary.BigEndian.Uint32(hdr[4:])
+	if n < 4 || n > maxControlLength {
+		return 0, fmt.Errorf("invalid control frame length %d", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(payload), nil
+}
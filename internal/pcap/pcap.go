@@ -0,0 +1,358 @@
+// Package pcap reads and writes DNS messages in the libpcap file format.
+// Written packets carry synthetic IP and UDP headers around the DNS payload,
+// whatever transport actually carried it, so any capture tool can decode them.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// Link types of the captures understood by Reader.
+const (
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+)
+
+const (
+	magicMicros = 0xa1b2c3d4
+	magicNanos  = 0xa1b23c4d
+
+	// maxRecordLength is libpcap's largest snapshot length, the limit of
+	// records in captures with a missing or larger one. Written captures
+	// declare it as their snapshot length: IPv6 packets carrying the largest
+	// datagrams are longer than 65535 bytes.
+	maxRecordLength = 262144
+
+	protoUDP = 17
+
+	// The largest payloads whose packets fit the 16-bit IPv4 total length
+	// and UDP length fields.
+	maxPayload4 = 0xffff - 20 - 8
+	maxPayload6 = 0xffff - 8
+)
+
+// Packet is a UDP datagram read from or written to a capture.
+type Packet struct {
+	Time    time.Time
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Payload []byte
+}
+
+// Writer appends packets to a capture file. It is safe for concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// Create creates the capture file at path and writes its header.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.file = f
+	return w, nil
+}
+
+// NewWriter writes a capture header with raw IP link type to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], magicMicros)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], maxRecordLength)
+	binary.LittleEndian.PutUint32(hdr[20:], LinkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket records payload as a UDP datagram from src to dst. Payloads
+// too large for a UDP datagram, such as the largest TCP responses, are not
+// written and an error is returned.
+func (w *Writer) WritePacket(ts time.Time, src, dst netip.AddrPort, payload []byte) error {
+	data, err := encodeUDP(src, dst, payload)
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, 16, 16+len(data))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(data)))
+	rec = append(rec, data...)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(rec)
+	return err
+}
+
+// Close closes the file opened by Create.
+func (w *Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// encodeUDP builds an IPv4 or IPv6 packet around a UDP datagram. IPv4-mapped
+// and mismatched addresses are unmapped or replaced so both ends share the
+// family of dst.
+func encodeUDP(src, dst netip.AddrPort, payload []byte) ([]byte, error) {
+	srcAddr, dstAddr := src.Addr().Unmap(), dst.Addr().Unmap()
+	if !dstAddr.IsValid() {
+		dstAddr = netip.IPv4Unspecified()
+	}
+	if !srcAddr.IsValid() || srcAddr.Is4() != dstAddr.Is4() {
+		srcAddr = netip.IPv4Unspecified()
+		if dstAddr.Is6() {
+			srcAddr = netip.IPv6Unspecified()
+		}
+	}
+	limit := maxPayload6
+	if dstAddr.Is4() {
+		limit = maxPayload4
+	}
+	if len(payload) > limit {
+		return nil, fmt.Errorf("%d byte message from %s to %s does not fit a UDP datagram", len(payload), src, dst)
+	}
+
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	var ip []byte
+	var pseudo []byte
+	s, d := srcAddr.AsSlice(), dstAddr.AsSlice()
+	if dstAddr.Is4() {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		ip[8] = 64
+		ip[9] = protoUDP
+		copy(ip[12:], s)
+		copy(ip[16:], d)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+		pseudo = append(append(append([]byte{}, s...), d...), 0, protoUDP, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		ip[6] = protoUDP
+		ip[7] = 64
+		copy(ip[8:], s)
+		copy(ip[24:], d)
+		pseudo = append(append(append([]byte{}, s...), d...), 0, 0, 0, 0, 0, 0, 0, protoUDP)
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(udp)))
+	}
+	sum := checksum(udp, checksum(pseudo, 0)^0xffff)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return append(ip, udp...), nil
+}
+
+// checksum returns the internet checksum of b, continuing from the
+// uncomplemented partial sum initial.
+func checksum(b []byte, initial uint16) uint16 {
+	sum := uint32(initial)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// Reader returns the UDP packets of a capture.
+type Reader struct {
+	r        io.Reader
+	file     *os.File
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+	snapLen  uint32
+}
+
+// Open opens the capture file at path.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	r.file = f
+	return r, nil
+}
+
+// NewReader reads the capture header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("reading capture header: %v", err)
+	}
+	reader := &Reader{r: r}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr) {
+		case magicMicros:
+			reader.order = order
+		case magicNanos:
+			reader.order, reader.nanos = order, true
+		}
+	}
+	if reader.order == nil {
+		return nil, errors.New("not a pcap file (pcapng is not supported)")
+	}
+	reader.linkType = reader.order.Uint32(hdr[20:])
+	reader.snapLen = reader.order.Uint32(hdr[16:])
+	if reader.snapLen == 0 || reader.snapLen > maxRecordLength {
+		reader.snapLen = maxRecordLength
+	}
+	switch reader.linkType {
+	case LinkTypeEthernet, LinkTypeRaw, LinkTypeLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", reader.linkType)
+	}
+	return reader, nil
+}
+
+// Next returns the next UDP packet, skipping anything else. It returns
+// io.EOF at the end of the capture.
+func (r *Reader) Next() (*Packet, error) {
+	rec := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r.r, rec); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated capture")
+			}
+			return nil, err
+		}
+		// Records cannot be longer than the snapshot length, so corrupt
+		// lengths are rejected before allocating.
+		n := r.order.Uint32(rec[8:])
+		if n > r.snapLen {
+			return nil, fmt.Errorf("invalid record length %d (snapshot length %d)", n, r.snapLen)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, errors.New("truncated capture")
+		}
+		frac := time.Duration(r.order.Uint32(rec[4:]))
+		if !r.nanos {
+			frac *= time.Microsecond
+		}
+		ts := time.Unix(int64(r.order.Uint32(rec[0:])), int64(frac))
+		if p := r.decode(data); p != nil {
+			p.Time = ts
+			return p, nil
+		}
+	}
+}
+
+// Close closes the file opened by Open.
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+func (r *Reader) decode(data []byte) *Packet {
+	switch r.linkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType, data := binary.BigEndian.Uint16(data[12:]), data[14:]
+		for etherType == 0x8100 && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil
+		}
+		return decodeIP(data)
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		return decodeIP(data[16:])
+	}
+	return decodeIP(data)
+}
+
+// decodeIP parses an unfragmented IPv4 or IPv6 packet carrying UDP. IPv6
+// extension headers are not followed.
+func decodeIP(data []byte) *Packet {
+	if len(data) < 1 {
+		return nil
+	}
+	var src, dst netip.Addr
+	var udp []byte
+	switch data[0] >> 4 {
+	case 4:
+		ihl := int(data[0]&0x0f) * 4
+		if len(data) < 20 || ihl < 20 || len(data) < ihl || data[9] != protoUDP {
+			return nil
+		}
+		if binary.BigEndian.Uint16(data[6:])&0x3fff != 0 {
+			return nil
+		}
+		end := min(int(binary.BigEndian.Uint16(data[2:])), len(data))
+		src, _ = netip.AddrFromSlice(data[12:16])
+		dst, _ = netip.AddrFromSlice(data[16:20])
+		if end < ihl {
+			return nil
+		}
+		udp = data[ihl:end]
+	case 6:
+		if len(data) < 40 || data[6] != protoUDP {
+			return nil
+		}
+		src, _ = netip.AddrFromSlice(data[8:24])
+		dst, _ = netip.AddrFromSlice(data[24:40])
+		udp = data[40:min(40+int(binary.BigEndian.Uint16(data[4:])), len(data))]
+	default:
+		return nil
+	}
+	if len(udp) < 8 {
+		return nil
+	}
+	end := min(int(binary.BigEndian.Uint16(udp[4:])), len(udp))
+	if end < 8 {
+		return nil
+	}
+	return &Packet{
+		Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(udp[0:])),
+		Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(udp[2:])),
+		Payload: append([]byte(nil), udp[8:end]...),
+	}
+}
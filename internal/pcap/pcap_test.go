@@ -0,0 +1,83 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 123456000)
+	client4 := netip.MustParseAddrPort("192.0.2.1:53000")
+	server4 := netip.MustParseAddrPort("198.51.100.53:53")
+	client6 := netip.MustParseAddrPort("[2001:db8::1]:53000")
+	server6 := netip.MustParseAddrPort("[2001:db8::53]:53")
+	packets := []Packet{
+		{Src: client4, Dst: server4, Payload: []byte{0x12, 0x34, 0x01, 0x00}},
+		{Src: server4, Dst: client4, Payload: bytes.Repeat([]byte{0xab}, maxPayload4)},
+		{Src: client6, Dst: server6, Payload: []byte{0x12, 0x34, 0x01, 0x00, 0x00}},
+		{Src: server6, Dst: client6, Payload: bytes.Repeat([]byte{0xcd}, maxPayload6)},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		if err := w.WritePacket(ts, p.Src, p.Dst, p.Payload); err != nil {
+			t.Fatalf("WritePacket(%d bytes): %v", len(p.Payload), err)
+		}
+	}
+	// Payloads too large for their packet's length fields are rejected
+	// without writing anything.
+	size := buf.Len()
+	if err := w.WritePacket(ts, server4, client4, make([]byte, maxPayload4+1)); err == nil {
+		t.Error("WritePacket accepted an oversized IPv4 payload")
+	}
+	if err := w.WritePacket(ts, server6, client6, make([]byte, maxPayload6+1)); err == nil {
+		t.Error("WritePacket accepted an oversized IPv6 payload")
+	}
+	if buf.Len() != size {
+		t.Errorf("rejected payloads wrote %d bytes", buf.Len()-size)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range packets {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if got.Src != want.Src || got.Dst != want.Dst || !got.Time.Equal(ts) {
+			t.Errorf("packet %s -> %s at %v, want %s -> %s at %v", got.Src, got.Dst, got.Time, want.Src, want.Dst, ts)
+		}
+		if !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("payload of %d bytes read back as %d bytes", len(want.Payload), len(got.Payload))
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next at end = %v, want io.EOF", err)
+	}
+}
+
+func TestNextRejectsCorruptLength(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rec := make([]byte, 16)
+	rec[8], rec[9], rec[10], rec[11] = 0xff, 0xff, 0xff, 0x7f
+	buf.Write(rec)
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next = %v, want an invalid length error", err)
+	}
+}
@@ -0,0 +1,174 @@
+package resolver
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type cacheKey struct {
+	scope  string
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// Cache stores upstream responses until the smallest TTL they carry expires.
+// Negative answers (NXDOMAIN and NODATA) are cached for the SOA minimum as
+// described in RFC 2308. When full, the least recently used entry is
+// dropped.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	size    int
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+func NewCache(size int) *Cache {
+	return &Cache{
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+		size:    size,
+	}
+}
+
+// keyFor builds the cache key of q. The scope separates answers obtained from
+// different upstream groups, which may legitimately differ.
+func keyFor(scope string, q dns.Question) cacheKey {
+	return cacheKey{scope: scope, name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}
+}
+
+// Get returns a copy of the cached response for q with TTLs reduced by the
+// time the entry has spent in the cache.
+func (c *Cache) Get(scope string, q dns.Question) (*dns.Msg, bool) {
+	if c.size <= 0 {
+		c.misses.Add(1)
+		return nil, false
+	}
+	now := time.Now()
+	c.mu.Lock()
+	var entry *cacheEntry
+	elem, ok := c.entries[keyFor(scope, q)]
+	if ok {
+		entry = elem.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+		} else {
+			c.remove(elem)
+			ok = false
+		}
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	msg := entry.msg.Copy()
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+	return msg, true
+}
+
+func (c *Cache) Set(scope string, q dns.Question, msg *dns.Msg) {
+	if c.size <= 0 {
+		return
+	}
+	ttl, ok := cacheTTL(msg)
+	if !ok || ttl == 0 {
+		return
+	}
+	now := time.Now()
+	entry := &cacheEntry{
+		key:     keyFor(scope, q),
+		msg:     msg.Copy(),
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+}
+
+// remove drops elem from the cache. Callers must hold c.mu.
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	c.entries = make(map[cacheKey]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+}
+
+// cacheTTL reports how long msg may be cached and whether it is cacheable at all.
+func cacheTTL(msg *dns.Msg) (uint32, bool) {
+	if msg.Truncated {
+		return 0, false
+	}
+	switch {
+	case msg.Rcode == dns.RcodeNameError, msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0:
+		for _, rr := range msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return min(soa.Hdr.Ttl, soa.Minttl), true
+			}
+		}
+		return 0, false
+	case msg.Rcode != dns.RcodeSuccess:
+		return 0, false
+	}
+	var ttl uint32
+	first := true
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl, true
+}
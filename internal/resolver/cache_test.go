@@ -0,0 +1,51 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func answer(q dns.Question, ip string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP(ip),
+	}}
+	return m
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(2)
+	a := dns.Question{Name: "a.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	b := dns.Question{Name: "b.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	d := dns.Question{Name: "d.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.Set("", a, answer(a, "192.0.2.1"))
+	c.Set("", b, answer(b, "192.0.2.2"))
+	if _, ok := c.Get("", a); !ok {
+		t.Fatal("a not cached")
+	}
+	// Overwriting a full cache's entry must not evict another one.
+	c.Set("", b, answer(b, "192.0.2.3"))
+	if _, ok := c.Get("", a); !ok {
+		t.Fatal("overwriting b evicted a")
+	}
+	if msg, ok := c.Get("", b); !ok || msg.Answer[0].(*dns.A).A.String() != "192.0.2.3" {
+		t.Fatalf("b = %v, %v; want the overwritten answer", msg, ok)
+	}
+
+	c.Get("", a)
+	c.Set("", d, answer(d, "192.0.2.4"))
+	if _, ok := c.Get("", b); ok {
+		t.Error("least recently used entry b was kept")
+	}
+	if _, ok := c.Get("", a); !ok {
+		t.Error("recently used entry a was evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
@@ -0,0 +1,220 @@
+package resolver
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"cDNS/internal/blocklist"
+	"cDNS/internal/dnstap"
+	"cDNS/internal/logger"
+	"cDNS/internal/rules"
+	"cDNS/internal/zone"
+)
+
+type Stats struct {
+	Queries      uint64          `json:"queries"`
+	Blocked      uint64          `json:"blocked"`
+	Local        uint64          `json:"local"`
+	CacheHits    uint64          `json:"cache_hits"`
+	CacheMisses  uint64          `json:"cache_misses"`
+	CacheEntries int             `json:"cache_entries"`
+	Upstreams    []UpstreamStats `json:"upstreams"`
+}
+
+// Server answers UDP and TCP clients from local zones or its cache, forwarding
+// everything else to the upstream pool. Without a pool only local zones are
+// served and other queries are refused.
+type Server struct {
+	pool      *Pool
+	cache     *Cache
+	blocklist *blocklist.Manager
+	zones     *zone.Set
+	rules     *rules.Rules
+	groups    map[string]*Pool
+	dnstap    *dnstap.Writer
+	queries   atomic.Uint64
+	blocked   atomic.Uint64
+	local     atomic.Uint64
+
+	mu      sync.Mutex
+	servers []*dns.Server
+}
+
+func NewServer(pool *Pool, cache *Cache) *Server {
+	return &Server{pool: pool, cache: cache}
+}
+
+// SetZones makes the server answer authoritatively for names inside zones.
+func (s *Server) SetZones(zones *zone.Set) {
+	s.zones = zones
+}
+
+// SetRules enables conditional forwarding: queries matching a rule are sent to
+// the pool of its group instead of the default pool.
+func (s *Server) SetRules(r *rules.Rules, groups map[string]*Pool) {
+	s.rules = r
+	s.groups = groups
+}
+
+// poolFor selects the upstream pool for q asked by the client at addr.
+func (s *Server) poolFor(q dns.Question, addr net.Addr) *Pool {
+	if s.rules == nil {
+		return s.pool
+	}
+	var client net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		client = a.IP
+	case *net.TCPAddr:
+		client = a.IP
+	}
+	if group, ok := s.rules.Match(q.Name, client); ok {
+		return s.groups[group]
+	}
+	return s.pool
+}
+
+// SetBlocklist makes the server answer names matched by m itself instead of
+// forwarding them.
+func (s *Server) SetBlocklist(m *blocklist.Manager) {
+	s.blocklist = m
+}
+
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.queries.Add(1)
+	if s.dnstap != nil {
+		w = s.tapClient(w, r)
+	}
+	if len(r.Question) != 1 {
+		reply := new(dns.Msg)
+		reply.SetRcode(r, dns.RcodeFormatError)
+		writeReply(w, r, reply)
+		return
+	}
+	q := r.Question[0]
+
+	if s.zones != nil {
+		if z, ok := s.zones.Find(q.Name); ok {
+			s.local.Add(1)
+			reply := z.Answer(r)
+			reply.RecursionAvailable = s.pool != nil || s.rules != nil
+			writeReply(w, r, reply)
+			return
+		}
+	}
+
+	if s.blocklist != nil {
+		if list, ok := s.blocklist.Match(q.Name); ok {
+			s.blocked.Add(1)
+			logger.GetLogger().Debug("Blocked query", zap.String("name", q.Name), zap.String("list", list))
+			writeReply(w, r, s.blocklist.Response(r))
+			return
+		}
+	}
+
+	pool := s.poolFor(q, w.RemoteAddr())
+	if pool == nil {
+		reply := new(dns.Msg)
+		reply.SetRcode(r, dns.RcodeRefused)
+		writeReply(w, r, reply)
+		return
+	}
+
+	if cached, ok := s.cache.Get(pool.Name, q); ok {
+		cached.Question = r.Question
+		writeReply(w, r, cached)
+		return
+	}
+
+	req := r.Copy()
+	req.RecursionDesired = true
+	resp, upstream, err := pool.Exchange(req)
+	if err != nil {
+		logger.GetLogger().Warn("Upstream query failed", zap.String("name", q.Name), zap.Error(err))
+		reply := new(dns.Msg)
+		reply.SetRcode(r, dns.RcodeServerFailure)
+		writeReply(w, r, reply)
+		return
+	}
+	logger.GetLogger().Debug("Forwarded query",
+		zap.String("name", q.Name),
+		zap.String("type", dns.TypeToString[q.Qtype]),
+		zap.String("upstream", upstream.Address),
+	)
+	s.cache.Set(pool.Name, q, resp)
+	resp.Id = r.Id
+	writeReply(w, r, resp)
+}
+
+// writeReply truncates UDP responses to the size the client advertised.
+func writeReply(w dns.ResponseWriter, req, reply *dns.Msg) {
+	reply.Id = req.Id
+	reply.Compress = true
+	if _, ok := w.LocalAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		reply.Truncate(size)
+	}
+	if err := w.WriteMsg(reply); err != nil {
+		logger.GetLogger().Debug("Failed to write DNS response", zap.Error(err))
+	}
+}
+
+// ListenAndServe serves addr over UDP and TCP and blocks until one of the
+// listeners fails or the server is shut down.
+func (s *Server) ListenAndServe(addr string) error {
+	servers := []*dns.Server{
+		{Addr: addr, Net: "udp", Handler: s},
+		{Addr: addr, Net: "tcp", Handler: s},
+	}
+	s.mu.Lock()
+	s.servers = servers
+	s.mu.Unlock()
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *dns.Server) {
+			errs <- srv.ListenAndServe()
+		}(srv)
+	}
+	return <-errs
+}
+
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(); err != nil {
+			logger.GetLogger().Debug("DNS listener shutdown", zap.String("net", srv.Net), zap.Error(err))
+		}
+	}
+}
+
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Queries:      s.queries.Load(),
+		Blocked:      s.blocked.Load(),
+		Local:        s.local.Load(),
+		CacheHits:    s.cache.hits.Load(),
+		CacheMisses:  s.cache.misses.Load(),
+		CacheEntries: s.cache.Len(),
+	}
+	if s.pool != nil {
+		stats.Upstreams = s.pool.Stats()
+	}
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats.Upstreams = append(stats.Upstreams, s.groups[name].Stats()...)
+	}
+	return stats
+}
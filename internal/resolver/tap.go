@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"cDNS/internal/dnstap"
+)
+
+// SetDnstap logs client queries and the responses sent to them to w as
+// CLIENT_QUERY and CLIENT_RESPONSE messages.
+func (s *Server) SetDnstap(w *dnstap.Writer) {
+	s.dnstap = w
+}
+
+// tapWriter logs the response written to a client.
+type tapWriter struct {
+	dns.ResponseWriter
+	tap       *dnstap.Writer
+	protocol  dnstap.Protocol
+	client    netip.AddrPort
+	server    netip.AddrPort
+	queryTime time.Time
+}
+
+// tapClient logs the query r and returns a writer logging its response.
+func (s *Server) tapClient(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	tw := &tapWriter{
+		ResponseWriter: w,
+		tap:            s.dnstap,
+		protocol:       dnstap.TCP,
+		client:         addrPort(w.RemoteAddr()),
+		server:         addrPort(w.LocalAddr()),
+		queryTime:      time.Now(),
+	}
+	if _, ok := w.LocalAddr().(*net.UDPAddr); ok {
+		tw.protocol = dnstap.UDP
+	}
+	// Handlers get the parsed query, so log it packed again.
+	packed, _ := r.Pack()
+	s.dnstap.Log(dnstap.Message{
+		Type:         dnstap.ClientQuery,
+		Protocol:     tw.protocol,
+		QueryAddr:    tw.client,
+		ResponseAddr: tw.server,
+		QueryTime:    tw.queryTime,
+		QueryMessage: packed,
+	})
+	return tw
+}
+
+func (w *tapWriter) WriteMsg(m *dns.Msg) error {
+	if err := w.ResponseWriter.WriteMsg(m); err != nil {
+		return err
+	}
+	packed, _ := m.Pack()
+	w.tap.Log(dnstap.Message{
+		Type:            dnstap.ClientResponse,
+		Protocol:        w.protocol,
+		QueryAddr:       w.client,
+		ResponseAddr:    w.server,
+		QueryTime:       w.queryTime,
+		ResponseTime:    time.Now(),
+		ResponseMessage: packed,
+	})
+	return nil
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	}
+	return netip.AddrPort{}
+}
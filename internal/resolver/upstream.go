@@ -0,0 +1,158 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"cDNS/internal/config"
+	ldns "cDNS/internal/dns"
+)
+
+const (
+	StrategyRandom   = "random"
+	StrategyFastest  = "fastest"
+	StrategyFailover = "failover"
+)
+
+type Upstream struct {
+	Address string
+
+	queries  atomic.Uint64
+	failures atomic.Uint64
+
+	mu           sync.Mutex
+	totalLatency time.Duration
+	lastLatency  time.Duration
+	smoothed     time.Duration
+}
+
+type UpstreamStats struct {
+	Group          string        `json:"group,omitempty"`
+	Address        string        `json:"address"`
+	Queries        uint64        `json:"queries"`
+	Failures       uint64        `json:"failures"`
+	AverageLatency time.Duration `json:"average_latency"`
+	LastLatency    time.Duration `json:"last_latency"`
+}
+
+func (u *Upstream) record(rtt time.Duration, err error, timeout time.Duration) {
+	u.queries.Add(1)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		u.failures.Add(1)
+		// Penalise failing upstreams so the fastest strategy moves away from them.
+		rtt = timeout
+	} else {
+		u.totalLatency += rtt
+		u.lastLatency = rtt
+	}
+	if u.smoothed == 0 {
+		u.smoothed = rtt
+	} else {
+		u.smoothed = (u.smoothed*7 + rtt) / 8
+	}
+}
+
+func (u *Upstream) latency() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.smoothed
+}
+
+func (u *Upstream) Stats() UpstreamStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	stats := UpstreamStats{
+		Address:     u.Address,
+		Queries:     u.queries.Load(),
+		Failures:    u.failures.Load(),
+		LastLatency: u.lastLatency,
+	}
+	if ok := stats.Queries - stats.Failures; ok > 0 {
+		stats.AverageLatency = u.totalLatency / time.Duration(ok)
+	}
+	return stats
+}
+
+// Pool forwards queries to a set of upstreams, choosing the order in which
+// they are tried according to its strategy.
+type Pool struct {
+	Name      string
+	upstreams []*Upstream
+	strategy  string
+	cfg       config.Config
+	recorder  ldns.Recorder
+}
+
+func NewPool(addresses []string, strategy string, cfg config.Config) (*Pool, error) {
+	switch strategy {
+	case StrategyRandom, StrategyFastest, StrategyFailover:
+	default:
+		return nil, fmt.Errorf("unknown upstream strategy %q", strategy)
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("at least one upstream is required")
+	}
+	pool := &Pool{strategy: strategy, cfg: cfg}
+	for _, address := range addresses {
+		if _, err := ldns.ParseEndpoint(address); err != nil {
+			return nil, fmt.Errorf("invalid upstream %q: %v", address, err)
+		}
+		pool.upstreams = append(pool.upstreams, &Upstream{Address: address})
+	}
+	return pool, nil
+}
+
+// SetRecorder passes every message exchanged with the upstreams to rec.
+func (p *Pool) SetRecorder(rec ldns.Recorder) {
+	p.recorder = rec
+}
+
+func (p *Pool) order() []*Upstream {
+	ordered := make([]*Upstream, len(p.upstreams))
+	copy(ordered, p.upstreams)
+	switch p.strategy {
+	case StrategyRandom:
+		rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	case StrategyFastest:
+		// Upstreams without measurements sort first so each one gets probed.
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].latency() < ordered[j].latency()
+		})
+	}
+	return ordered
+}
+
+// Exchange forwards m to the upstreams in strategy order and returns the first
+// response received.
+func (p *Pool) Exchange(m *dns.Msg) (*dns.Msg, *Upstream, error) {
+	var lastErr error
+	for _, upstream := range p.order() {
+		r, rtt, err := ldns.Exchange(m, upstream.Address, p.cfg, p.recorder)
+		upstream.record(rtt, err, p.cfg.Timeout)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", upstream.Address, err)
+			continue
+		}
+		return r, upstream, nil
+	}
+	return nil, nil, lastErr
+}
+
+func (p *Pool) Stats() []UpstreamStats {
+	stats := make([]UpstreamStats, 0, len(p.upstreams))
+	for _, upstream := range p.upstreams {
+		upstreamStats := upstream.Stats()
+		upstreamStats.Group = p.Name
+		stats = append(stats, upstreamStats)
+	}
+	return stats
+}
@@ -0,0 +1,145 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule sends names under one of Domains, asked by a client inside one of
+// Clients, to the upstream group Group. An empty Domains or Clients list
+// matches everything.
+type Rule struct {
+	Domains []string `yaml:"domains" json:"domains,omitempty"`
+	Clients []string `yaml:"clients" json:"clients,omitempty"`
+	Group   string   `yaml:"group" json:"group"`
+
+	networks []*net.IPNet
+}
+
+// Rules is a conditional forwarding configuration. Rules are evaluated in
+// file order and the first match wins; names matching no rule use Default.
+//
+//	groups:
+//	  vpn: [10.8.0.1, tls://10.8.0.2]
+//	  public: [1.1.1.1, 9.9.9.9]
+//	rules:
+//	  - domains: [corp.internal]
+//	    group: vpn
+//	default: public
+type Rules struct {
+	Groups  map[string][]string `yaml:"groups" json:"groups"`
+	Rules   []Rule              `yaml:"rules" json:"rules"`
+	Default string              `yaml:"default" json:"default,omitempty"`
+}
+
+// Load reads a rules file. JSON files are accepted as well since JSON is a
+// subset of YAML.
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Rules)
+	if err := yaml.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := r.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+func (r *Rules) validate() error {
+	for name, servers := range r.Groups {
+		if len(servers) == 0 {
+			return fmt.Errorf("group %q has no nameservers", name)
+		}
+	}
+	if _, ok := r.Groups[r.Default]; r.Default != "" && !ok {
+		return fmt.Errorf("default group %q is not defined", r.Default)
+	}
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if _, ok := r.Groups[rule.Group]; !ok {
+			return fmt.Errorf("rule %d refers to undefined group %q", i+1, rule.Group)
+		}
+		for j, domain := range rule.Domains {
+			if _, ok := dns.IsDomainName(domain); !ok {
+				return fmt.Errorf("rule %d has invalid domain %q", i+1, domain)
+			}
+			rule.Domains[j] = dns.CanonicalName(domain)
+		}
+		for _, client := range rule.Clients {
+			if !strings.Contains(client, "/") {
+				if ip := net.ParseIP(client); ip != nil && ip.To4() != nil {
+					client += "/32"
+				} else {
+					client += "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(client)
+			if err != nil {
+				return fmt.Errorf("rule %d has invalid client %q", i+1, client)
+			}
+			rule.networks = append(rule.networks, network)
+		}
+	}
+	return nil
+}
+
+func (rule *Rule) matches(name string, client net.IP) bool {
+	if len(rule.networks) > 0 {
+		if client == nil {
+			return false
+		}
+		found := false
+		for _, network := range rule.networks {
+			if network.Contains(client) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(rule.Domains) == 0 {
+		return true
+	}
+	for _, domain := range rule.Domains {
+		if dns.IsSubDomain(domain, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the upstream group for name asked by client. A nil client
+// only matches rules without client restrictions.
+func (r *Rules) Match(name string, client net.IP) (string, bool) {
+	name = dns.CanonicalName(name)
+	for i := range r.Rules {
+		if r.Rules[i].matches(name, client) {
+			return r.Rules[i].Group, true
+		}
+	}
+	if r.Default != "" {
+		return r.Default, true
+	}
+	return "", false
+}
+
+// Nameservers returns the nameservers of the group selected for name when
+// queried from this host.
+func (r *Rules) Nameservers(name string) []string {
+	group, ok := r.Match(name, nil)
+	if !ok {
+		return nil
+	}
+	return r.Groups[group]
+}
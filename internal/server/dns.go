@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"cDNS/internal/api"
+	"cDNS/internal/blocklist"
+	"cDNS/internal/catalog"
+	"cDNS/internal/config"
+	"cDNS/internal/dnstap"
+	"cDNS/internal/logger"
+	"cDNS/internal/resolver"
+	"cDNS/internal/rules"
+	"cDNS/internal/zone"
+)
+
+func RunServe(cmd *cobra.Command, args []string) {
+	cfg := config.GetConfigFromFlags(cmd)
+	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
+
+	listen, _ := cmd.Flags().GetString("listen")
+	upstreams, _ := cmd.Flags().GetStringSlice("upstream")
+	strategy, _ := cmd.Flags().GetString("strategy")
+	cacheSize, _ := cmd.Flags().GetInt("cache-size")
+	apiPort, _ := cmd.Flags().GetInt("api-port")
+	blocklists, _ := cmd.Flags().GetStringSlice("blocklist")
+	allowlists, _ := cmd.Flags().GetStringSlice("allowlist")
+	blockResponse, _ := cmd.Flags().GetString("block-response")
+	blocklistDir, _ := cmd.Flags().GetString("blocklist-dir")
+	zoneSpecs, _ := cmd.Flags().GetStringSlice("zone")
+	rulesFile, _ := cmd.Flags().GetString("rules")
+	dnstapSpec, _ := cmd.Flags().GetString("dnstap")
+
+	// Upstream exchanges are logged as RESOLVER_* and client traffic as CLIENT_*.
+	var tap *dnstap.Writer
+	if dnstapSpec != "" {
+		var err error
+		tap, err = dnstap.Open(dnstapSpec)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to open dnstap output", zap.Error(err))
+		}
+		defer tap.Close()
+	}
+
+	zones := zone.NewSet()
+	for _, spec := range zoneSpecs {
+		origin, path, err := zone.ParseSpec(spec)
+		if err != nil {
+			logger.GetLogger().Fatal("Invalid zone", zap.Error(err))
+		}
+		z, err := zone.Load(origin, path)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to load zone", zap.String("path", path), zap.Error(err))
+		}
+		zones.Add(z)
+	}
+
+	var ruleSet *rules.Rules
+	groups := make(map[string]*resolver.Pool)
+	if rulesFile != "" {
+		var err error
+		ruleSet, err = rules.Load(rulesFile)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to load rules", zap.Error(err))
+		}
+		for name, servers := range ruleSet.Groups {
+			group, err := resolver.NewPool(servers, strategy, cfg)
+			if err != nil {
+				logger.GetLogger().Fatal("Invalid upstream group", zap.String("group", name), zap.Error(err))
+			}
+			group.Name = name
+			if tap != nil {
+				group.SetRecorder(tap.Resolver())
+			}
+			groups[name] = group
+		}
+	}
+
+	var pool *resolver.Pool
+	if len(upstreams) > 0 || (zones.Len() == 0 && ruleSet == nil) {
+		var err error
+		pool, err = resolver.NewPool(upstreams, strategy, cfg)
+		if err != nil {
+			logger.GetLogger().Fatal("Invalid upstream configuration", zap.Error(err))
+		}
+		if tap != nil {
+			pool.SetRecorder(tap.Resolver())
+		}
+	}
+	dnsServer := resolver.NewServer(pool, resolver.NewCache(cacheSize))
+	dnsServer.SetZones(zones)
+	if tap != nil {
+		dnsServer.SetDnstap(tap)
+	}
+	if ruleSet != nil {
+		dnsServer.SetRules(ruleSet, groups)
+	}
+
+	lists, err := blocklist.NewManager(blockResponse)
+	if err != nil {
+		logger.GetLogger().Fatal("Invalid block response", zap.Error(err))
+	}
+	if blocklistDir != "" {
+		if err := lists.SetDir(blocklistDir); err != nil {
+			logger.GetLogger().Fatal("Invalid blocklist directory", zap.Error(err))
+		}
+	}
+	for _, spec := range blocklists {
+		name, path := blocklist.ParseSpec(spec)
+		if err := lists.Add(name, path, false); err != nil {
+			logger.GetLogger().Fatal("Failed to load blocklist", zap.String("path", path), zap.Error(err))
+		}
+	}
+	for _, spec := range allowlists {
+		name, path := blocklist.ParseSpec(spec)
+		if err := lists.Add(name, path, true); err != nil {
+			logger.GetLogger().Fatal("Failed to load allowlist", zap.String("path", path), zap.Error(err))
+		}
+	}
+	dnsServer.SetBlocklist(lists)
+
+	serverErrors := make(chan error, 2)
+	go func() {
+		logger.GetLogger().Info("Starting DNS server",
+			zap.String("listen", listen),
+			zap.Strings("upstreams", upstreams),
+			zap.Strings("zones", zoneSpecs),
+			zap.String("strategy", strategy),
+		)
+		if err := dnsServer.ListenAndServe(listen); err != nil {
+			serverErrors <- err
+		}
+	}()
+
+	var apiServer *http.Server
+	if apiPort > 0 {
+		h := api.NewHandler(logger.GetLogger())
+		h.SetQueryDefaults(cfg)
+		if tap != nil {
+			h.SetRecorder(tap)
+		}
+		h.SetResolver(dnsServer)
+		h.SetBlocklists(lists)
+		h.SetupRoutes()
+		apiServer = newHTTPServer(apiPort, h.GetRouter())
+		go func() {
+			logger.GetLogger().Info("Starting API server", zap.Int("port", apiPort))
+			if err := apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErrors <- err
+			}
+		}()
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case <-hup:
+			logger.GetLogger().Info("Reloading lists")
+			if err := lists.Reload(); err != nil {
+				logger.GetLogger().Error("Failed to reload lists", zap.Error(err))
+			}
+		case <-quit:
+			logger.GetLogger().Info("Received shutdown signal")
+			break wait
+		case err = <-serverErrors:
+			logger.GetLogger().Fatal("Server failed to start", zap.Error(err))
+		}
+	}
+
+	logger.GetLogger().Info("Shutting down server...")
+	dnsServer.Shutdown()
+	if apiServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err = apiServer.Shutdown(ctx); err != nil {
+			logger.GetLogger().Fatal("Server forced to shutdown", zap.Error(err))
+		}
+	}
+
+	logger.GetLogger().Info("Server exiting")
+}
@@ -14,15 +14,29 @@ import (
 	"github.com/spf13/cobra"
 
 	"cDNS/internal/api"
+	"cDNS/internal/catalog"
 	"cDNS/internal/config"
+	"cDNS/internal/dnstap"
 	"cDNS/internal/logger"
 )
 
 func RunAPI(cmd *cobra.Command, args []string) {
 	cfg := config.GetConfigFromFlags(cmd)
 	logger.InitLogger(cfg.LogLevel)
+	if err := catalog.Init(cfg.CatalogFile); err != nil {
+		logger.GetLogger().Fatal("Failed to load resolver catalog", zap.Error(err))
+	}
 
 	h := api.NewHandler(logger.GetLogger())
+	h.SetQueryDefaults(cfg)
+	if spec, _ := cmd.Flags().GetString("dnstap"); spec != "" {
+		tap, err := dnstap.Open(spec)
+		if err != nil {
+			logger.GetLogger().Fatal("Failed to open dnstap output", zap.Error(err))
+		}
+		defer tap.Close()
+		h.SetRecorder(tap)
+	}
 	h.SetupRoutes()
 	r := h.GetRouter()
 
@@ -31,14 +45,7 @@ func RunAPI(cmd *cobra.Command, args []string) {
 		logger.GetLogger().Fatal("Failed to get port flag", zap.Error(err))
 	}
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: r,
-
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+	srv := newHTTPServer(port, r)
 
 	// Create a channel to capture server errors
 	serverErrors := make(chan error, 1)
@@ -74,3 +81,14 @@ func RunAPI(cmd *cobra.Command, args []string) {
 
 	logger.GetLogger().Info("Server exiting")
 }
+
+func newHTTPServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
+
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+}
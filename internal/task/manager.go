@@ -4,6 +4,7 @@ import (
 	"cDNS/internal/config"
 	"cDNS/internal/dns"
 	"cDNS/internal/logger"
+	"cDNS/internal/pcap"
 	"encoding/json"
 	"fmt"
 	"go.uber.org/zap"
@@ -22,6 +23,7 @@ type BackgroundTask struct {
 	CreatedAt   time.Time    `json:"created_at"`
 	CompletedAt *time.Time   `json:"completed_at,omitempty"`
 	Error       string       `json:"error,omitempty"`
+	PcapFile    string       `json:"pcap_file,omitempty"`
 }
 
 type TaskManager struct {
@@ -58,13 +60,59 @@ func (tm *TaskManager) GetTasks(status string) []*BackgroundTask {
 	return tasks
 }
 
-func ProcessBackgroundTask(taskID string, req struct {
+// QueryRequest is the body accepted by the query API endpoints.
+type QueryRequest struct {
 	Domain      string   `json:"domain" binding:"required"`
 	Nameservers []string `json:"nameservers" binding:"required"`
 	Timeout     int      `json:"timeout,omitempty"`
 	Retries     int      `json:"retries,omitempty"`
 	Filter      []string `json:"filter,omitempty"`
-}) {
+	IPVersion   int      `json:"ip_version,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Interface   string   `json:"interface,omitempty"`
+	NameMode    string   `json:"name_mode,omitempty"`
+	Follow      bool     `json:"follow,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Ndots       int      `json:"ndots,omitempty"`
+	Pcap        bool     `json:"pcap,omitempty"`
+}
+
+// Config converts the request into a query configuration. Fields left empty
+// in the request fall back to defaults, typically the API server's flags.
+func (req QueryRequest) Config(defaults config.Config) config.Config {
+	cfg := config.Config{
+		Timeout:       time.Duration(req.Timeout) * time.Second,
+		Retries:       req.Retries,
+		RecordFilter:  req.Filter,
+		IPVersion:     req.IPVersion,
+		SourceAddress: req.Source,
+		Interface:     req.Interface,
+		Follow:        req.Follow,
+		Search:        req.Search,
+		Ndots:         req.Ndots,
+	}
+	if cfg.IPVersion == 0 {
+		cfg.IPVersion = defaults.IPVersion
+	}
+	if cfg.SourceAddress == "" && cfg.Interface == "" {
+		cfg.SourceAddress = defaults.SourceAddress
+		cfg.Interface = defaults.Interface
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 3
+	}
+	if cfg.Ndots == 0 {
+		cfg.Ndots = dns.DefaultNdots
+	}
+	return cfg
+}
+
+// ProcessBackgroundTask runs the query of a task, passing every message
+// exchanged to rec unless it is nil and to the task's capture if requested.
+func ProcessBackgroundTask(taskID string, req QueryRequest, cfg config.Config, rec dns.Recorder) {
 	Manager.mutex.Lock()
 	task := Manager.tasks[taskID]
 	task.Status = "running"
@@ -75,26 +123,26 @@ func ProcessBackgroundTask(taskID string, req struct {
 		task.CompletedAt = &completedAt
 		Manager.mutex.Unlock()
 	}()
-	cfg := config.Config{
-		Timeout:      time.Duration(req.Timeout) * time.Second,
-		Retries:      req.Retries,
-		RecordFilter: req.Filter,
+	domain, err := dns.ToASCII(req.Domain)
+	if err != nil {
+		Manager.mutex.Lock()
+		task.Status = "failed"
+		task.Error = err.Error()
+		Manager.mutex.Unlock()
+		return
 	}
-	if cfg.Timeout == 0 {
-		cfg.Timeout = 5 * time.Second
+	mode, err := dns.ParseValidationMode(req.NameMode)
+	if err == nil {
+		err = dns.ValidateDomain(domain, mode)
 	}
-	if cfg.Retries == 0 {
-		cfg.Retries = 3
-	}
-	domain := req.Domain
-	if !dns.IsValidDomain(domain) {
+	if err != nil {
 		Manager.mutex.Lock()
 		task.Status = "failed"
-		task.Error = "Invalid domain"
+		task.Error = "Invalid domain: " + err.Error()
 		Manager.mutex.Unlock()
 		return
 	}
-	nameservers := dns.PrepareNameservers(req.Nameservers)
+	nameservers := dns.PrepareNameservers(req.Nameservers, cfg.IPVersion)
 	if len(nameservers) == 0 {
 		Manager.mutex.Lock()
 		task.Status = "failed"
@@ -102,12 +150,29 @@ func ProcessBackgroundTask(taskID string, req struct {
 		Manager.mutex.Unlock()
 		return
 	}
+	base := fmt.Sprintf("dns_results_%s_%d", strings.ReplaceAll(req.Domain, ".", "_"), time.Now().Unix())
+	if req.Pcap {
+		w, err := pcap.Create(base + ".pcap")
+		if err != nil {
+			logger.GetLogger().Error("Failed to create packet capture", zap.Error(err))
+			Manager.mutex.Lock()
+			task.Status = "failed"
+			task.Error = "Failed to create packet capture"
+			Manager.mutex.Unlock()
+			return
+		}
+		defer w.Close()
+		rec = dns.Recorders(rec, dns.PcapRecorder(w))
+		Manager.mutex.Lock()
+		task.PcapFile = base + ".pcap"
+		Manager.mutex.Unlock()
+	}
 	var results []dns.Result
 	for _, ns := range nameservers {
-		result := dns.Nameserver(domain, ns, cfg)
+		result := dns.Nameserver(domain, ns, cfg, rec)
 		results = append(results, result)
 	}
-	filename := fmt.Sprintf("dns_results_%s_%d.json", strings.ReplaceAll(req.Domain, ".", "_"), time.Now().Unix())
+	filename := base + ".json"
 	if err := saveResultsToFile(results, filename); err != nil {
 		logger.GetLogger().Error("Failed to save results to file", zap.Error(err))
 		Manager.mutex.Lock()
@@ -0,0 +1,54 @@
+package zone
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Set holds the zones served locally and picks the most specific one for a name.
+type Set struct {
+	mu    sync.RWMutex
+	zones map[string]*Zone
+}
+
+func NewSet() *Set {
+	return &Set{zones: make(map[string]*Zone)}
+}
+
+// ParseSpec splits an "origin=path" zone specification.
+func ParseSpec(spec string) (string, string, error) {
+	origin, path, ok := strings.Cut(spec, "=")
+	if !ok || origin == "" || path == "" {
+		return "", "", fmt.Errorf("zone must be given as origin=path, got %q", spec)
+	}
+	return origin, path, nil
+}
+
+func (s *Set) Add(z *Zone) {
+	s.mu.Lock()
+	s.zones[z.Origin] = z
+	s.mu.Unlock()
+}
+
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.zones)
+}
+
+// Find returns the zone with the longest origin containing name.
+func (s *Set) Find(name string) (*Zone, bool) {
+	name = dns.CanonicalName(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if z, ok := s.zones[name[off:]]; ok {
+			return z, true
+		}
+	}
+	z, ok := s.zones["."]
+	return z, ok
+}
@@ -0,0 +1,206 @@
+package zone
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChase bounds how many in-zone CNAMEs are followed for one answer.
+const maxCNAMEChase = 8
+
+// Zone is an RFC 1035 master file held in memory and answered authoritatively.
+type Zone struct {
+	Origin string
+	Path   string
+	SOA    *dns.SOA
+
+	names        map[string]map[uint16][]dns.RR
+	nonTerminals map[string]bool
+}
+
+func Load(origin, path string) (*Zone, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	z, err := Parse(origin, file, path)
+	if err != nil {
+		return nil, err
+	}
+	z.Path = path
+	return z, nil
+}
+
+func Parse(origin string, r io.Reader, filename string) (*Zone, error) {
+	z := &Zone{
+		Origin:       dns.CanonicalName(origin),
+		names:        make(map[string]map[uint16][]dns.RR),
+		nonTerminals: make(map[string]bool),
+	}
+	zp := dns.NewZoneParser(r, z.Origin, filename)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if err := z.Insert(rr); err != nil {
+			return nil, err
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if z.SOA == nil {
+		return nil, fmt.Errorf("zone %s has no SOA record at the apex", z.Origin)
+	}
+	return z, nil
+}
+
+func (z *Zone) Insert(rr dns.RR) error {
+	hdr := rr.Header()
+	name := dns.CanonicalName(hdr.Name)
+	if !dns.IsSubDomain(z.Origin, name) {
+		return fmt.Errorf("record %s is outside zone %s", hdr.Name, z.Origin)
+	}
+	if soa, ok := rr.(*dns.SOA); ok {
+		if name != z.Origin {
+			return fmt.Errorf("SOA record %s is not at the zone apex", hdr.Name)
+		}
+		z.SOA = soa
+	}
+	if z.names[name] == nil {
+		z.names[name] = make(map[uint16][]dns.RR)
+	}
+	z.names[name][hdr.Rrtype] = append(z.names[name][hdr.Rrtype], rr)
+	if name == z.Origin {
+		return nil
+	}
+	for off, end := dns.NextLabel(name, 0); !end && name[off:] != z.Origin; off, end = dns.NextLabel(name, off) {
+		z.nonTerminals[name[off:]] = true
+	}
+	return nil
+}
+
+// Records returns every RRset in the zone keyed by canonical owner name.
+func (z *Zone) Records() map[string]map[uint16][]dns.RR {
+	return z.names
+}
+
+func (z *Zone) exists(name string) bool {
+	_, ok := z.names[name]
+	return ok || z.nonTerminals[name]
+}
+
+// Answer builds the authoritative response to req, which must carry exactly
+// one question for a name inside the zone.
+func (z *Zone) Answer(req *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	reply.Authoritative = true
+	q := req.Question[0]
+	z.answer(reply, dns.CanonicalName(q.Name), q.Name, q.Qtype, 0)
+	return reply
+}
+
+func (z *Zone) answer(reply *dns.Msg, name, owner string, qtype uint16, depth int) {
+	if z.referral(reply, name, qtype) {
+		return
+	}
+	rrsets, ok := z.names[name]
+	switch {
+	case ok:
+	case z.nonTerminals[name]:
+		z.noData(reply)
+		return
+	default:
+		wildcard := "*." + z.closestEncloser(name)
+		if rrsets, ok = z.names[wildcard]; !ok {
+			reply.Rcode = dns.RcodeNameError
+			z.noData(reply)
+			return
+		}
+	}
+
+	if qtype == dns.TypeANY {
+		for _, rrs := range rrsets {
+			reply.Answer = append(reply.Answer, withOwner(rrs, owner)...)
+		}
+		return
+	}
+	if rrs, ok := rrsets[qtype]; ok {
+		reply.Answer = append(reply.Answer, withOwner(rrs, owner)...)
+		return
+	}
+	if cnames, ok := rrsets[dns.TypeCNAME]; ok {
+		reply.Answer = append(reply.Answer, withOwner(cnames, owner)...)
+		target := cnames[0].(*dns.CNAME).Target
+		if depth < maxCNAMEChase && dns.IsSubDomain(z.Origin, dns.CanonicalName(target)) && !inAnswer(reply, target) {
+			z.answer(reply, dns.CanonicalName(target), target, qtype, depth+1)
+		}
+		return
+	}
+	z.noData(reply)
+}
+
+// referral fills in a delegation response if name lies at or below a zone cut.
+func (z *Zone) referral(reply *dns.Msg, name string, qtype uint16) bool {
+	labels := dns.SplitDomainName(name)
+	apexLabels := dns.CountLabel(z.Origin)
+	for i := len(labels) - apexLabels - 1; i >= 0; i-- {
+		cut := dns.Fqdn(strings.Join(labels[i:], "."))
+		// DS records live on the parent side of the cut.
+		if cut == name && qtype == dns.TypeDS {
+			return false
+		}
+		nsSet, ok := z.names[cut][dns.TypeNS]
+		if !ok {
+			continue
+		}
+		reply.Authoritative = false
+		reply.Ns = append(reply.Ns, nsSet...)
+		for _, rr := range nsSet {
+			host := dns.CanonicalName(rr.(*dns.NS).Ns)
+			reply.Extra = append(reply.Extra, z.names[host][dns.TypeA]...)
+			reply.Extra = append(reply.Extra, z.names[host][dns.TypeAAAA]...)
+		}
+		return true
+	}
+	return false
+}
+
+func (z *Zone) closestEncloser(name string) string {
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if z.exists(name[off:]) {
+			return name[off:]
+		}
+	}
+	return z.Origin
+}
+
+// noData adds the SOA record used for negative caching, with its TTL capped at
+// the SOA minimum as RFC 2308 requires.
+func (z *Zone) noData(reply *dns.Msg) {
+	soa := dns.Copy(z.SOA).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	reply.Ns = append(reply.Ns, soa)
+}
+
+func withOwner(rrs []dns.RR, owner string) []dns.RR {
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = owner
+		out = append(out, rr)
+	}
+	return out
+}
+
+func inAnswer(reply *dns.Msg, name string) bool {
+	for _, rr := range reply.Answer {
+		if strings.EqualFold(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}